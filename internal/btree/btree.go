@@ -1,17 +1,24 @@
 package btree
 
 import (
+	"container/heap"
 	"errors"
 	"slices"
 	"strings"
+	"sync"
 )
 
 type node struct {
-	keys     []string
-	children []*node
+	keys        []string
+	frequencies []int
+	children    []*node
+	// maxFrequency is the maximum key frequency in the subtree rooted at the node
+	maxFrequency int
 }
 
+// BTree is safe for concurrent use: lookups run in parallel, insertions are exclusive.
 type BTree struct {
+	mu       sync.RWMutex
 	root     *node
 	minOrder int
 }
@@ -27,6 +34,9 @@ func New(minOrder int) (*BTree, error) {
 }
 
 func (t *BTree) SearchKey(key string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	currentVertex := t.root
 
 	for currentVertex != nil {
@@ -44,52 +54,124 @@ func (t *BTree) SearchKey(key string) bool {
 }
 
 func (t *BTree) SearchByPrefix(prefix string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	results := make([]string, 0)
 	t.root.searchByPrefix(&prefix, &results)
 	slices.Sort(results)
 	return results
 }
 
+// Complete returns at most n keys with the given prefix, ordered by frequency
+// descending and lexicographically among equal frequencies. Keys with zero
+// frequency are never returned. Subtrees are visited best-first by their
+// maximum frequency, so only the part of the tree that can contain top keys is walked.
+func (t *BTree) Complete(prefix string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	results := make([]string, 0, n)
+
+	queue := completionQueue{{node: t.root, frequency: t.root.maxFrequency}}
+	for queue.Len() > 0 && len(results) < n {
+		item := heap.Pop(&queue).(*completionItem)
+		if item.frequency <= 0 {
+			break
+		}
+		if item.node == nil {
+			results = append(results, item.key)
+			continue
+		}
+
+		v := item.node
+		for j, key := range v.keys {
+			if strings.HasPrefix(key, prefix) {
+				heap.Push(&queue, &completionItem{key: key, frequency: v.frequencies[j]})
+			}
+		}
+		for j, child := range v.children {
+			if (j == len(v.keys) || v.keys[j] >= prefix) &&
+				(j == 0 || v.keys[j-1] < prefix || strings.HasPrefix(v.keys[j-1], prefix)) {
+				heap.Push(&queue, &completionItem{node: child, frequency: child.maxFrequency})
+			}
+		}
+	}
+
+	return results
+}
+
 func (t *BTree) Insert(key string) (found bool) {
-	_, _, found = t.insert(t.root, key)
+	return t.AddFrequency(key, 0)
+}
+
+// AddFrequency inserts key if it is missing and adds delta to its frequency.
+func (t *BTree) AddFrequency(key string, delta int) (found bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, _, _, found = t.insert(t.root, key, delta)
 	return
 }
 
-func (t *BTree) insert(v *node, key string) (newParentKey string, newParentChild *node, found bool) {
+func (t *BTree) insert(v *node, key string, delta int) (newParentKey string, newParentFrequency int, newParentChild *node, found bool) {
 	if v == nil {
 		return
 	}
+	isRoot := v == t.root
+	defer func() {
+		v.updateMaxFrequency()
+		if newParentChild != nil {
+			newParentChild.updateMaxFrequency()
+			if isRoot {
+				t.root.updateMaxFrequency()
+			}
+		}
+	}()
 
 	index, keyFound := slices.BinarySearch(v.keys, key)
 	if keyFound {
+		v.frequencies[index] += delta
 		found = true
 		return
 	}
 
 	if v.isLeaf() {
 		if len(v.keys) < t.minOrder*2-1 {
-			v.keys = append(v.keys[:index], append([]string{key}, v.keys[index:]...)...)
+			v.keys = slices.Insert(v.keys, index, key)
+			v.frequencies = slices.Insert(v.frequencies, index, delta)
 		} else {
 			newParentKey = v.keys[t.minOrder-1]
-			newParentChild = &node{keys: append([]string{}, v.keys[t.minOrder:]...)}
+			newParentFrequency = v.frequencies[t.minOrder-1]
+			newParentChild = &node{
+				keys:        append([]string{}, v.keys[t.minOrder:]...),
+				frequencies: append([]int{}, v.frequencies[t.minOrder:]...),
+			}
 			v.keys = v.keys[:t.minOrder-1]
+			v.frequencies = v.frequencies[:t.minOrder-1]
 
 			if key < newParentKey {
-				v.keys = append(v.keys[:index], append([]string{key}, v.keys[index:]...)...)
+				v.keys = slices.Insert(v.keys, index, key)
+				v.frequencies = slices.Insert(v.frequencies, index, delta)
 			} else {
 				index = index - t.minOrder
-				newParentChild.keys = append(newParentChild.keys[:index], append([]string{key}, newParentChild.keys[index:]...)...)
+				newParentChild.keys = slices.Insert(newParentChild.keys, index, key)
+				newParentChild.frequencies = slices.Insert(newParentChild.frequencies, index, delta)
 			}
 
 			if v == t.root {
 				t.root = &node{
-					keys:     []string{newParentKey},
-					children: []*node{v, newParentChild},
+					keys:        []string{newParentKey},
+					frequencies: []int{newParentFrequency},
+					children:    []*node{v, newParentChild},
 				}
 			}
 		}
 	} else {
-		newKey, newChild, keyFound := t.insert(v.children[index], key)
+		newKey, newFrequency, newChild, keyFound := t.insert(v.children[index], key, delta)
 		if newChild == nil {
 			found = keyFound
 			return
@@ -97,30 +179,37 @@ func (t *BTree) insert(v *node, key string) (newParentKey string, newParentChild
 
 		index, _ = slices.BinarySearch(v.keys, newKey)
 		if len(v.keys) < t.minOrder*2-1 {
-			v.keys = append(v.keys[:index], append([]string{newKey}, v.keys[index:]...)...)
-			v.children = append(v.children[:index+1], append([]*node{newChild}, v.children[index+1:]...)...)
+			v.keys = slices.Insert(v.keys, index, newKey)
+			v.frequencies = slices.Insert(v.frequencies, index, newFrequency)
+			v.children = slices.Insert(v.children, index+1, newChild)
 		} else {
 			newParentKey = v.keys[t.minOrder-1]
+			newParentFrequency = v.frequencies[t.minOrder-1]
 			newParentChild = &node{
-				keys:     append([]string{}, v.keys[t.minOrder:]...),
-				children: append([]*node{}, v.children[t.minOrder:]...),
+				keys:        append([]string{}, v.keys[t.minOrder:]...),
+				frequencies: append([]int{}, v.frequencies[t.minOrder:]...),
+				children:    append([]*node{}, v.children[t.minOrder:]...),
 			}
 			v.keys = v.keys[:t.minOrder-1]
+			v.frequencies = v.frequencies[:t.minOrder-1]
 			v.children = v.children[:t.minOrder]
 
 			if newKey < newParentKey {
-				v.keys = append(v.keys[:index], append([]string{newKey}, v.keys[index:]...)...)
-				v.children = append(v.children[:index+1], append([]*node{newChild}, v.children[index+1:]...)...)
+				v.keys = slices.Insert(v.keys, index, newKey)
+				v.frequencies = slices.Insert(v.frequencies, index, newFrequency)
+				v.children = slices.Insert(v.children, index+1, newChild)
 			} else {
 				index = index - t.minOrder
-				newParentChild.keys = append(newParentChild.keys[:index], append([]string{newKey}, newParentChild.keys[index:]...)...)
-				newParentChild.children = append(newParentChild.children[:index+1], append([]*node{newChild}, newParentChild.children[index+1:]...)...)
+				newParentChild.keys = slices.Insert(newParentChild.keys, index, newKey)
+				newParentChild.frequencies = slices.Insert(newParentChild.frequencies, index, newFrequency)
+				newParentChild.children = slices.Insert(newParentChild.children, index+1, newChild)
 			}
 
 			if v == t.root {
 				t.root = &node{
-					keys:     []string{newParentKey},
-					children: []*node{v, newParentChild},
+					keys:        []string{newParentKey},
+					frequencies: []int{newParentFrequency},
+					children:    []*node{v, newParentChild},
 				}
 			}
 		}
@@ -150,6 +239,16 @@ func (v *node) searchByPrefix(prefix *string, results *[]string) {
 	}
 }
 
+func (v *node) updateMaxFrequency() {
+	v.maxFrequency = 0
+	for _, frequency := range v.frequencies {
+		v.maxFrequency = max(v.maxFrequency, frequency)
+	}
+	for _, child := range v.children {
+		v.maxFrequency = max(v.maxFrequency, child.maxFrequency)
+	}
+}
+
 func (v *node) isLeaf() bool {
 	return v != nil && len(v.children) == 0
 }
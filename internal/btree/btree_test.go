@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -103,3 +104,35 @@ func TestBTree_SearchByPrefix(t *testing.T) {
 	}
 
 }
+
+func TestBTree_Complete(t *testing.T) {
+	tree, err := New(4)
+	require.NoError(t, err)
+
+	frequencies := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		s := randString()
+		delta := rand.Intn(100)
+		frequencies[s] += delta
+		tree.AddFrequency(s, delta)
+	}
+
+	for c := uint8('a'); c <= 'z'; c++ {
+		expected := make([]string, 0)
+		for s, frequency := range frequencies {
+			if s[0] == c && frequency > 0 {
+				expected = append(expected, s)
+			}
+		}
+		slices.SortFunc(expected, func(a, b string) int {
+			if frequencies[a] != frequencies[b] {
+				return frequencies[b] - frequencies[a]
+			}
+			return strings.Compare(a, b)
+		})
+
+		require.Equal(t, expected[:10], tree.Complete(string(c), 10))
+	}
+
+	require.Empty(t, tree.Complete("zzzzzzzzzzzzzzzzzzzzzzzzzzzz", 10))
+}
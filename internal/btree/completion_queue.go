@@ -0,0 +1,41 @@
+package btree
+
+// completionItem is either a key (node == nil) with its frequency or
+// a subtree with the maximum frequency found in it.
+type completionItem struct {
+	key       string
+	node      *node
+	frequency int
+}
+
+type completionQueue []*completionItem
+
+func (pq completionQueue) Len() int { return len(pq) }
+
+func (pq completionQueue) Less(i, j int) bool {
+	if pq[i].frequency != pq[j].frequency {
+		return pq[i].frequency > pq[j].frequency
+	}
+	// subtrees go first so that every key of equal frequency is known before emitting
+	if (pq[i].node == nil) != (pq[j].node == nil) {
+		return pq[i].node != nil
+	}
+	return pq[i].key < pq[j].key
+}
+
+func (pq completionQueue) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+}
+
+func (pq *completionQueue) Push(x interface{}) {
+	element := x.(*completionItem)
+	*pq = append(*pq, element)
+}
+
+func (pq *completionQueue) Pop() interface{} {
+	old := *pq
+	n := len(old)
+	element := old[n-1]
+	*pq = old[0 : n-1]
+	return element
+}
@@ -0,0 +1,209 @@
+package document_store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Document struct {
+	ID          uint16            `json:"id"`
+	ExternalID  string            `json:"external_id"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	CreatedTime time.Time         `json:"created_time"`
+	DieTime     *time.Time        `json:"die_time,omitempty"`
+}
+
+type operation string
+
+const (
+	putOperation      operation = "put"
+	putBatchOperation operation = "put_batch"
+	deleteOperation   operation = "delete"
+)
+
+// record is a single line of the document store log
+type record struct {
+	Operation operation   `json:"op"`
+	Document  *Document   `json:"document,omitempty"`
+	Documents []*Document `json:"documents,omitempty"`
+	ID        uint16      `json:"id,omitempty"`
+}
+
+// DocumentStore keeps stored documents in memory and persists every change
+// to an append-only log, which is replayed on Open.
+type DocumentStore struct {
+	file        *os.File
+	documents   map[uint16]*Document
+	externalIDs map[string]uint16
+}
+
+// New creates an empty document store, discarding the log at path if it exists.
+func New(path string) (*DocumentStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
+	}
+
+	return &DocumentStore{
+		file:        file,
+		documents:   make(map[uint16]*Document),
+		externalIDs: make(map[string]uint16),
+	}, nil
+}
+
+// Open restores the document store from the log at path, creating it if needed.
+func Open(path string) (*DocumentStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileOpening, err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0660)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileOpening, err)
+	}
+
+	s := &DocumentStore{
+		file:        file,
+		documents:   make(map[uint16]*Document),
+		externalIDs: make(map[string]uint16),
+	}
+
+	reader := bufio.NewReader(file)
+	validSize := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a torn last record is dropped, so that new records are not appended to it
+			if err = file.Truncate(validSize); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrFileOpening, err)
+			}
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDecodingRecord, err)
+		}
+
+		var r record
+		if err = json.Unmarshal(line, &r); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDecodingRecord, err)
+		}
+		s.apply(&r)
+		validSize += int64(len(line))
+	}
+
+	return s, nil
+}
+
+// Put stores document, replacing the one with the same ID.
+func (s *DocumentStore) Put(document Document) error {
+	document.Fields = maps.Clone(document.Fields)
+	r := &record{Operation: putOperation, Document: &document}
+	if err := s.write(r); err != nil {
+		return err
+	}
+	s.apply(r)
+	return nil
+}
+
+// PutBatch stores all documents, replacing the ones with the same IDs. They are logged
+// as a single record, so after a crash either all of them are restored or none.
+func (s *DocumentStore) PutBatch(documents []Document) error {
+	r := &record{Operation: putBatchOperation, Documents: make([]*Document, len(documents))}
+	for j, document := range documents {
+		document.Fields = maps.Clone(document.Fields)
+		r.Documents[j] = &document
+	}
+	if err := s.write(r); err != nil {
+		return err
+	}
+	s.apply(r)
+	return nil
+}
+
+func (s *DocumentStore) Delete(id uint16) error {
+	r := &record{Operation: deleteOperation, ID: id}
+	if err := s.write(r); err != nil {
+		return err
+	}
+	s.apply(r)
+	return nil
+}
+
+func (s *DocumentStore) Get(id uint16) (Document, bool) {
+	document, ok := s.documents[id]
+	if !ok {
+		return Document{}, false
+	}
+	return copyDocument(document), true
+}
+
+// LookupByExternalID returns the latest stored document with the given external ID.
+func (s *DocumentStore) LookupByExternalID(externalID string) (Document, bool) {
+	id, ok := s.externalIDs[externalID]
+	if !ok {
+		return Document{}, false
+	}
+	return s.Get(id)
+}
+
+func (s *DocumentStore) Close() error {
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
+
+func (s *DocumentStore) write(r *record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEncodingRecord, err)
+	}
+	// a record is written with a single call, so a crash can only tear the last line
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingRecord, err)
+	}
+	return nil
+}
+
+func (s *DocumentStore) apply(r *record) {
+	switch r.Operation {
+	case putOperation:
+		s.put(r.Document)
+	case putBatchOperation:
+		for _, document := range r.Documents {
+			s.put(document)
+		}
+	case deleteOperation:
+		if old, ok := s.documents[r.ID]; ok {
+			if s.externalIDs[old.ExternalID] == old.ID {
+				delete(s.externalIDs, old.ExternalID)
+			}
+			delete(s.documents, r.ID)
+		}
+	}
+}
+
+func (s *DocumentStore) put(document *Document) {
+	if old, ok := s.documents[document.ID]; ok && s.externalIDs[old.ExternalID] == old.ID {
+		delete(s.externalIDs, old.ExternalID)
+	}
+	s.documents[document.ID] = document
+	s.externalIDs[document.ExternalID] = document.ID
+}
+
+func copyDocument(document *Document) Document {
+	c := *document
+	c.Fields = maps.Clone(document.Fields)
+	if document.DieTime != nil {
+		dieTime := *document.DieTime
+		c.DieTime = &dieTime
+	}
+	return c
+}
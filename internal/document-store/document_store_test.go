@@ -0,0 +1,86 @@
+package document_store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "documents")
+
+	store, err := New(path)
+	require.NoError(t, err)
+
+	dieTime := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, store.Put(Document{
+		ID:          0,
+		ExternalID:  "a.txt",
+		Fields:      map[string]string{"author": "shakespeare"},
+		CreatedTime: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		DieTime:     &dieTime,
+	}))
+	require.NoError(t, store.Put(Document{ID: 1, ExternalID: "b.txt"}))
+	require.NoError(t, store.Put(Document{ID: 2, ExternalID: "c.txt"}))
+	require.NoError(t, store.Delete(1))
+	require.NoError(t, store.Close())
+
+	// simulate a crash in the middle of writing a record
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0660)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"op":"put","docu`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	store, err = Open(path)
+	require.NoError(t, err)
+
+	document, ok := store.Get(0)
+	require.True(t, ok)
+	require.Equal(t, "a.txt", document.ExternalID)
+	require.Equal(t, "shakespeare", document.Fields["author"])
+	require.True(t, dieTime.Equal(*document.DieTime))
+
+	_, ok = store.Get(1)
+	require.False(t, ok)
+	_, ok = store.LookupByExternalID("b.txt")
+	require.False(t, ok)
+
+	require.NoError(t, store.Put(Document{ID: 3, ExternalID: "d.txt"}))
+	require.NoError(t, store.Close())
+
+	store, err = Open(path)
+	require.NoError(t, err)
+	document, ok = store.LookupByExternalID("d.txt")
+	require.True(t, ok)
+	require.Equal(t, uint16(3), document.ID)
+	require.NoError(t, store.Close())
+}
+
+func TestDocumentStore_PutBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "documents")
+
+	store, err := New(path)
+	require.NoError(t, err)
+	require.NoError(t, store.PutBatch([]Document{{ID: 0, ExternalID: "a.txt"}, {ID: 1, ExternalID: "b.txt"}}))
+	require.NoError(t, store.Close())
+
+	// a batch torn by a crash is dropped as a whole
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0660)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"op":"put_batch","documents":[{"id":2,"external_id":"c.txt"},{"id":3,`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	store, err = Open(path)
+	require.NoError(t, err)
+	document, ok := store.LookupByExternalID("b.txt")
+	require.True(t, ok)
+	require.Equal(t, uint16(1), document.ID)
+	_, ok = store.Get(2)
+	require.False(t, ok)
+	require.NoError(t, store.Close())
+}
@@ -0,0 +1,11 @@
+package document_store
+
+import "errors"
+
+var (
+	ErrDecodingRecord = errors.New("failed to decode document store record")
+	ErrEncodingRecord = errors.New("failed to encode document store record")
+	ErrFileCreating   = errors.New("failed to create file")
+	ErrFileOpening    = errors.New("failed to open file")
+	ErrWritingRecord  = errors.New("failed to write document store record")
+)
@@ -0,0 +1,132 @@
+package inverted_index
+
+import (
+	"io"
+	"maps"
+	"runtime"
+	"slices"
+	"strings"
+
+	"golang.org/x/example/hello/reverse"
+
+	document_store "inverted-index/internal/document-store"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// BatchDocument is a single document of a batch passed to IndexBatch.
+type BatchDocument struct {
+	Reader io.Reader
+	Meta   DocumentMeta
+}
+
+type termPosting struct {
+	term   string
+	docIDs []uint16
+}
+
+// IndexBatch indexes all documents at once and returns their IDs in the same order.
+// Documents are analyzed in parallel; posting lists of the whole batch are built in
+// memory and handed to the storage in one step, which is much faster than adding
+// the documents one by one. The batch is indexed as a whole or, on error, not at all,
+// though the IDs it took are not given to other documents.
+func (i *InvertedIndex) IndexBatch(documents []BatchDocument) ([]uint16, error) {
+	analyzedDocuments := make([]*analyzedDocument, len(documents))
+	err := i.analyzeDocuments(documents, runtime.GOMAXPROCS(0), func(j int, document *analyzedDocument) error {
+		analyzedDocuments[j] = document
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.dropExpired(); err != nil {
+		return nil, err
+	}
+	if int(i.documentsNumber)+len(documents) > maxDocumentsNumber {
+		return nil, ErrTooManyDocuments
+	}
+
+	// the IDs are taken before anything is written, like in indexDocument
+	docIDs := make([]uint16, len(documents))
+	termPostings := make(map[string]*termPosting)
+	// date posting lists are kept by bit, since nearly every document has many date keys
+	var createdPostings, diePostings [64][]uint16
+	for j, document := range analyzedDocuments {
+		docID := i.documentsNumber + uint16(j)
+		docIDs[j] = docID
+
+		// doc IDs grow with j, so every posting list stays sorted
+		for k, key := range document.keys {
+			posting, ok := termPostings[key]
+			if !ok {
+				posting = &termPosting{term: document.terms[k]}
+				termPostings[key] = posting
+			}
+			posting.docIDs = append(posting.docIDs, docID)
+		}
+		for bit := range 64 {
+			if document.createdBits>>bit&1 == 1 {
+				createdPostings[bit] = append(createdPostings[bit], docID)
+			}
+			if document.dieBits>>bit&1 == 1 {
+				diePostings[bit] = append(diePostings[bit], docID)
+			}
+		}
+	}
+	if err := i.forwardLog.put(docIDs, analyzedDocuments); err != nil {
+		return nil, err
+	}
+	i.documentsNumber += uint16(len(documents))
+
+	containers := make(map[string]roaring_bitmap.Container, len(termPostings)+2*64)
+	for key, posting := range termPostings {
+		containers[key] = roaring_bitmap.FromSortedValues(posting.docIDs)
+	}
+	for bit := range 64 {
+		if len(createdPostings[bit]) > 0 {
+			containers[createdTimeKeys[bit]] = roaring_bitmap.FromSortedValues(createdPostings[bit])
+		}
+		if len(diePostings[bit]) > 0 {
+			containers[dieTimeKeys[bit]] = roaring_bitmap.FromSortedValues(diePostings[bit])
+		}
+	}
+	if err := i.storage.AddContainers(containers); err != nil {
+		return nil, i.abandon(docIDs, err)
+	}
+
+	// storing the documents commits the batch
+	storedDocuments := make([]document_store.Document, len(documents))
+	for j, document := range documents {
+		storedDocuments[j] = document_store.Document{
+			ID:          docIDs[j],
+			ExternalID:  document.Meta.ExternalID,
+			Fields:      document.Meta.Fields,
+			CreatedTime: document.Meta.CreatedTime,
+			DieTime:     document.Meta.DieTime,
+		}
+	}
+	if err := i.documentStore.PutBatch(storedDocuments); err != nil {
+		return nil, i.abandon(docIDs, err)
+	}
+
+	postings := slices.Collect(maps.Values(termPostings))
+	// sorted insertion keeps the dictionaries' nodes warm in cache
+	slices.SortFunc(postings, func(a, b *termPosting) int {
+		return strings.Compare(a.term, b.term)
+	})
+	for _, posting := range postings {
+		// every document of a posting list has the term once, so it is the document frequency
+		i.dict.AddFrequency(posting.term, len(posting.docIDs))
+		i.reverseDict.Insert(reverse.String(posting.term))
+	}
+
+	for j, document := range analyzedDocuments {
+		i.setDieTime(docIDs[j], documents[j].Meta.DieTime)
+		i.forwardIndex[docIDs[j]] = document
+	}
+
+	return docIDs, nil
+}
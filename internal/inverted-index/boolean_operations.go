@@ -10,6 +10,18 @@ func (i *InvertedIndex) Or(c1 roaring_bitmap.Container, c2 roaring_bitmap.Contai
 	return roaring_bitmap.Or(c1, c2)
 }
 
+// Not returns the documents not present in c, deleted and expired documents excluded.
 func (i *InvertedIndex) Not(c roaring_bitmap.Container) roaring_bitmap.Container {
-	return roaring_bitmap.Not(c, i.documentsNumber)
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.not(c)
+}
+
+func (i *InvertedIndex) not(c roaring_bitmap.Container) roaring_bitmap.Container {
+	excluded := i.storage.Tombstones()
+	if i.ttl != nil {
+		excluded = roaring_bitmap.Or(excluded, i.ttl.Expired())
+	}
+	return roaring_bitmap.AndNot(roaring_bitmap.Not(c, i.documentsNumber), excluded)
 }
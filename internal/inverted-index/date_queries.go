@@ -21,6 +21,10 @@ func (i *InvertedIndex) DateQueryCreated(timeStart time.Time, timeEnd time.Time)
 	if timeStart.After(timeEnd) {
 		return nil, errInvalidRange
 	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	after, err := i.dateQueryAfter(timeStart, createdTime)
 	if err != nil {
 		return nil, err
@@ -36,6 +40,10 @@ func (i *InvertedIndex) DateQueryValid(timeStart time.Time, timeEnd time.Time) (
 	if timeStart.After(timeEnd) {
 		return nil, errInvalidRange
 	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	createdBefore, err := i.dateQueryBefore(timeStart, createdTime)
 	if err != nil {
 		return nil, err
@@ -55,11 +63,7 @@ func (i *InvertedIndex) dateQueryAfter(timeAfter time.Time, tmType timeType) (ro
 	wasFirstSetBit := false
 
 	for j := 63; j >= 0; j-- {
-		searchBit := uint16(j)
-		if tmType == dieTime {
-			searchBit += 64
-		}
-		currentBitContainer, err := i.storage.Search(searchBit)
+		currentBitContainer, err := i.storage.Search(dateKey(tmType, j))
 		if err != nil {
 			return nil, err
 		}
@@ -71,7 +75,7 @@ func (i *InvertedIndex) dateQueryAfter(timeAfter time.Time, tmType timeType) (ro
 			// while current jth bit set to 1 => strictly greater
 			res = i.Or(res, i.And(requiredPrefix, currentBitContainer))
 
-			requiredPrefix = i.And(requiredPrefix, i.Not(currentBitContainer))
+			requiredPrefix = i.And(requiredPrefix, i.not(currentBitContainer))
 		} else {
 			if !wasFirstSetBit {
 				wasFirstSetBit = true
@@ -93,5 +97,5 @@ func (i *InvertedIndex) dateQueryBefore(timeBefore time.Time, tmType timeType) (
 	if err != nil {
 		return nil, err
 	}
-	return i.Not(res), nil
+	return i.not(res), nil
 }
@@ -0,0 +1,437 @@
+package inverted_index
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/example/hello/reverse"
+
+	document_store "inverted-index/internal/document-store"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// DocumentMeta describes a document besides its text.
+type DocumentMeta struct {
+	// ExternalID is the caller's identifier of the document, e.g. a path or a key
+	ExternalID  string
+	Fields      map[string]string
+	CreatedTime time.Time
+	DieTime     *time.Time
+}
+
+type analyzedDocument struct {
+	// terms are the distinct raw terms of the document
+	terms []string
+	// keys are the storage keys of the terms, in the same order
+	keys []string
+	// createdBits and dieBits tell which date keys the document has, a bit for every
+	// bit of the Unix time; they are kept apart from keys, since most documents share them
+	createdBits uint64
+	dieBits     uint64
+}
+
+func (d *analyzedDocument) allKeys() []string {
+	keys := slices.Clip(d.keys)
+	for bit := range 64 {
+		if d.createdBits>>bit&1 == 1 {
+			keys = append(keys, createdTimeKeys[bit])
+		}
+		if d.dieBits>>bit&1 == 1 {
+			keys = append(keys, dieTimeKeys[bit])
+		}
+	}
+	return keys
+}
+
+// AddDocument indexes the file and stores it with filePath as the external ID.
+func (i *InvertedIndex) AddDocument(filePath string, createdTime time.Time, dieTime *time.Time) error {
+	return i.AddDocumentWithFields(filePath, nil, createdTime, dieTime)
+}
+
+// AddDocumentWithFields indexes the file and stores fields alongside it, retrievable with GetDocument.
+func (i *InvertedIndex) AddDocumentWithFields(filePath string, fields map[string]string, createdTime time.Time, dieTime *time.Time) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = i.AddDocumentReader(file, DocumentMeta{
+		ExternalID:  filePath,
+		Fields:      fields,
+		CreatedTime: createdTime,
+		DieTime:     dieTime,
+	})
+	return err
+}
+
+// AddDocumentText indexes text and returns the ID assigned to the document.
+func (i *InvertedIndex) AddDocumentText(text string, meta DocumentMeta) (uint16, error) {
+	return i.AddDocumentReader(strings.NewReader(text), meta)
+}
+
+// AddDocumentReader indexes everything read from r and returns the ID assigned to the document.
+func (i *InvertedIndex) AddDocumentReader(r io.Reader, meta DocumentMeta) (uint16, error) {
+	document, err := i.analyzeDocument(r, meta.CreatedTime, meta.DieTime)
+	if err != nil {
+		return 0, err
+	}
+
+	return i.indexDocument(document, meta)
+}
+
+func (i *InvertedIndex) indexDocument(document *analyzedDocument, meta DocumentMeta) (uint16, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.dropExpired(); err != nil {
+		return 0, err
+	}
+	if int(i.documentsNumber) >= maxDocumentsNumber {
+		return 0, ErrTooManyDocuments
+	}
+
+	// the ID is taken and logged before anything else is written, so that a document failing
+	// halfway never leaves postings or a stored document under the ID of another one
+	docID := i.documentsNumber
+	if err := i.forwardLog.put([]uint16{docID}, []*analyzedDocument{document}); err != nil {
+		return 0, err
+	}
+	i.documentsNumber++
+	for _, key := range document.allKeys() {
+		if err := i.storage.Add([]byte(key), docID); err != nil {
+			return 0, i.abandon([]uint16{docID}, err)
+		}
+	}
+	// storing the document commits it
+	err := i.documentStore.Put(document_store.Document{
+		ID:          docID,
+		ExternalID:  meta.ExternalID,
+		Fields:      meta.Fields,
+		CreatedTime: meta.CreatedTime,
+		DieTime:     meta.DieTime,
+	})
+	if err != nil {
+		return 0, i.abandon([]uint16{docID}, err)
+	}
+
+	for _, term := range document.terms {
+		// dict frequencies are document frequencies, so every term is counted once per document
+		i.dict.AddFrequency(term, 1)
+		i.reverseDict.Insert(reverse.String(term))
+	}
+
+	i.setDieTime(docID, meta.DieTime)
+	i.forwardIndex[docID] = document
+	return docID, nil
+}
+
+// abandon does not give the IDs to other documents, which could otherwise match the
+// postings left behind if deleting them fails; Open deletes them again if they are logged.
+func (i *InvertedIndex) abandon(docIDs []uint16, err error) error {
+	for _, docID := range docIDs {
+		if deleteErr := i.storage.Delete(docID); deleteErr != nil {
+			return errors.Join(err, deleteErr)
+		}
+		if deleteErr := i.forwardLog.delete(docID); deleteErr != nil {
+			return errors.Join(err, deleteErr)
+		}
+	}
+	return err
+}
+
+// UpdateDocument replaces the indexed contents and timestamps of an existing document
+// with the ones of the file, keeping its external ID and stored fields.
+func (i *InvertedIndex) UpdateDocument(docID uint16, filePath string, createdTime time.Time, dieTime *time.Time) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return i.UpdateDocumentReader(docID, file, DocumentMeta{
+		CreatedTime: createdTime,
+		DieTime:     dieTime,
+	})
+}
+
+// UpdateDocumentText is UpdateDocumentReader for in-memory text.
+func (i *InvertedIndex) UpdateDocumentText(docID uint16, text string, meta DocumentMeta) error {
+	return i.UpdateDocumentReader(docID, strings.NewReader(text), meta)
+}
+
+// UpdateDocumentReader replaces the indexed contents and timestamps of an existing document.
+// Empty external ID and nil fields in meta keep the stored ones.
+// Concurrent queries observe either the old or the new version of the document.
+func (i *InvertedIndex) UpdateDocumentReader(docID uint16, r io.Reader, meta DocumentMeta) error {
+	document, err := i.analyzeDocument(r, meta.CreatedTime, meta.DieTime)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err = i.dropExpired(); err != nil {
+		return err
+	}
+	oldDocument, ok := i.forwardIndex[docID]
+	if !ok {
+		return ErrDocumentNotFound
+	}
+
+	// until the update completes, the document may have the keys of both versions, which
+	// are logged together so that a later update or delete removes all of them
+	if err = i.forwardLog.put([]uint16{docID}, []*analyzedDocument{mergeDocuments(oldDocument, document)}); err != nil {
+		return err
+	}
+
+	storedDocument, _ := i.documentStore.Get(docID)
+	if meta.ExternalID != "" {
+		storedDocument.ExternalID = meta.ExternalID
+	}
+	if meta.Fields != nil {
+		storedDocument.Fields = meta.Fields
+	}
+	storedDocument.CreatedTime = meta.CreatedTime
+	storedDocument.DieTime = meta.DieTime
+	if err = i.documentStore.Put(storedDocument); err != nil {
+		return err
+	}
+
+	keys := document.allKeys()
+	newKeys := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		newKeys[key] = struct{}{}
+		err = i.storage.Add([]byte(key), docID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range oldDocument.allKeys() {
+		if _, ok = newKeys[key]; !ok {
+			if err = i.storage.Remove([]byte(key), docID); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the terms of an expired document are already out of the dictionaries
+	oldTerms := make(map[string]struct{}, len(oldDocument.terms))
+	if _, expired := i.expired[docID]; !expired {
+		for _, term := range oldDocument.terms {
+			oldTerms[term] = struct{}{}
+		}
+	}
+	for _, term := range document.terms {
+		if _, ok = oldTerms[term]; ok {
+			delete(oldTerms, term)
+		} else {
+			i.dict.AddFrequency(term, 1)
+			i.reverseDict.Insert(reverse.String(term))
+		}
+	}
+	for term := range oldTerms {
+		i.dict.AddFrequency(term, -1)
+	}
+	if err = i.forwardLog.put([]uint16{docID}, []*analyzedDocument{document}); err != nil {
+		return err
+	}
+
+	i.setDieTime(docID, meta.DieTime)
+	i.forwardIndex[docID] = document
+	delete(i.expired, docID)
+	return nil
+}
+
+// DeleteDocument excludes the document from all subsequent query results.
+func (i *InvertedIndex) DeleteDocument(docID uint16) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.dropExpired(); err != nil {
+		return err
+	}
+	document, ok := i.forwardIndex[docID]
+	if !ok {
+		return ErrDocumentNotFound
+	}
+
+	if err := i.documentStore.Delete(docID); err != nil {
+		return err
+	}
+	if err := i.storage.Delete(docID); err != nil {
+		return err
+	}
+	if err := i.forwardLog.delete(docID); err != nil {
+		return err
+	}
+	i.dropTerms(docID, document)
+
+	i.setDieTime(docID, nil)
+	delete(i.forwardIndex, docID)
+	delete(i.expired, docID)
+	return nil
+}
+
+func mergeDocuments(a, b *analyzedDocument) *analyzedDocument {
+	merged := &analyzedDocument{
+		terms:       slices.Clone(a.terms),
+		keys:        slices.Clone(a.keys),
+		createdBits: a.createdBits | b.createdBits,
+		dieBits:     a.dieBits | b.dieBits,
+	}
+	terms := make(map[string]struct{}, len(a.terms))
+	for _, term := range a.terms {
+		terms[term] = struct{}{}
+	}
+	for k, term := range b.terms {
+		if _, ok := terms[term]; !ok {
+			merged.terms = append(merged.terms, term)
+			merged.keys = append(merged.keys, b.keys[k])
+		}
+	}
+	return merged
+}
+
+func (i *InvertedIndex) setDieTime(docID uint16, dieTime *time.Time) {
+	if i.ttl == nil {
+		return
+	}
+	if dieTime == nil {
+		i.ttl.ClearDieTime(docID)
+	} else {
+		i.ttl.SetDieTime(docID, *dieTime)
+	}
+}
+
+// dropExpiredTerms leaves the documents found by ID until the storage drops them.
+func (i *InvertedIndex) dropExpiredTerms() {
+	if i.ttl == nil {
+		return
+	}
+	if expired := i.ttl.Expired(); expired != nil {
+		for _, docID := range expired.ConvertToArray().Values {
+			if document, ok := i.forwardIndex[docID]; ok {
+				i.dropTerms(docID, document)
+				i.expired[docID] = struct{}{}
+			}
+		}
+	}
+}
+
+// dropExpired does not delete the postings of the documents the storage already dropped.
+func (i *InvertedIndex) dropExpired() error {
+	if i.ttl == nil {
+		return nil
+	}
+	i.dropExpiredTerms()
+
+	// the documents are kept until they are deleted, so that a failure is retried
+	i.forgotten = roaring_bitmap.Or(i.forgotten, i.ttl.TakeForgotten())
+	if i.forgotten == nil {
+		return nil
+	}
+	for _, docID := range i.forgotten.ConvertToArray().Values {
+		document, ok := i.forwardIndex[docID]
+		if !ok {
+			continue
+		}
+		if err := i.documentStore.Delete(docID); err != nil {
+			return err
+		}
+		if err := i.forwardLog.delete(docID); err != nil {
+			return err
+		}
+		i.dropTerms(docID, document)
+		delete(i.forwardIndex, docID)
+		delete(i.expired, docID)
+	}
+	i.forgotten = nil
+	return nil
+}
+
+func (i *InvertedIndex) dropTerms(docID uint16, document *analyzedDocument) {
+	if _, expired := i.expired[docID]; expired {
+		return
+	}
+	for _, term := range document.terms {
+		i.dict.AddFrequency(term, -1)
+	}
+}
+
+// GetDocument returns the stored document with the given internal ID.
+func (i *InvertedIndex) GetDocument(docID uint16) (document_store.Document, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	document, ok := i.documentStore.Get(docID)
+	if !ok {
+		return document_store.Document{}, ErrDocumentNotFound
+	}
+	return document, nil
+}
+
+// LookupByExternalID returns the stored document that was added with the given external ID.
+func (i *InvertedIndex) LookupByExternalID(externalID string) (document_store.Document, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	document, ok := i.documentStore.LookupByExternalID(externalID)
+	if !ok {
+		return document_store.Document{}, ErrDocumentNotFound
+	}
+	return document, nil
+}
+
+func (i *InvertedIndex) analyzeDocument(r io.Reader, createdTime time.Time, dieTime *time.Time) (*analyzedDocument, error) {
+	document := &analyzedDocument{}
+	// distinct terms have distinct keys, so only terms are deduplicated
+	documentTerms := make(map[string]struct{})
+
+	t := newTokenizer(r)
+	defer t.close()
+	for {
+		term, err := t.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if _, ok := documentTerms[term]; ok {
+			continue
+		}
+		toAdd, processedTerm := i.processTerm(term)
+		if toAdd {
+			documentTerms[term] = struct{}{}
+			document.terms = append(document.terms, term)
+			document.keys = append(document.keys, string(processedTerm))
+		}
+	}
+
+	createdTimeUnix := createdTime.Unix()
+	dieTimeUnix := int64(math.MaxInt64)
+	if dieTime != nil {
+		dieTimeUnix = dieTime.Unix()
+	}
+
+	for j := 0; createdTimeUnix > 0 || dieTimeUnix > 0; j++ {
+		if createdTimeUnix&1 == 1 {
+			document.createdBits |= 1 << j
+		}
+		createdTimeUnix >>= 1
+
+		if dieTimeUnix&1 == 1 {
+			document.dieBits |= 1 << j
+		}
+		dieTimeUnix >>= 1
+	}
+
+	return document, nil
+}
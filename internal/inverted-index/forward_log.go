@@ -0,0 +1,238 @@
+package inverted_index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const forwardLogFile = "forward"
+
+var (
+	ErrReadingForwardLog = errors.New("error reading forward index log")
+	ErrWritingForwardLog = errors.New("error writing forward index log")
+)
+
+type forwardOperation uint8
+
+const (
+	forwardPut forwardOperation = iota
+	forwardDelete
+)
+
+type forwardRecord struct {
+	operation forwardOperation
+	docID     uint16
+	document  *analyzedDocument
+}
+
+// forwardHeaderSize is the size of the checksum and the length preceding every record payload
+const forwardHeaderSize = 8
+
+var forwardChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// forwardLog persists the forward index of an opened index, and with it the IDs taken
+// so far. A document is logged and synced before anything else of it is written, so
+// that its ID is never given to another document after a crash, even if the storage
+// made its postings durable. The log of an index created with New is nil and drops
+// every change.
+type forwardLog struct {
+	file *os.File
+}
+
+// readForwardLog drops everything from the first damaged record on.
+func readForwardLog(path string) ([]forwardRecord, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingForwardLog, err)
+	}
+	defer file.Close()
+
+	return readForwardRecords(bufio.NewReader(file))
+}
+
+func readForwardRecords(reader io.Reader) ([]forwardRecord, error) {
+	var records []forwardRecord
+	header := make([]byte, forwardHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadingForwardLog, err)
+		}
+		checksum := binary.LittleEndian.Uint32(header[:4])
+		length := binary.LittleEndian.Uint32(header[4:])
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadingForwardLog, err)
+		}
+		if crc32.Checksum(payload, forwardChecksumTable) != checksum {
+			return records, nil
+		}
+		record, ok := forwardRecordFromBytes(payload)
+		if !ok {
+			return records, nil
+		}
+		records = append(records, record)
+	}
+}
+
+// createForwardLog replaces the log at path atomically.
+func createForwardLog(path string, records []forwardRecord) (*forwardLog, error) {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingForwardLog, err)
+	}
+	l := &forwardLog{file: file}
+	if err = l.write(records); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %w", ErrWritingForwardLog, err)
+	}
+	if err = file.Close(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingForwardLog, err)
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingForwardLog, err)
+	}
+
+	l.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingForwardLog, err)
+	}
+	return l, nil
+}
+
+func (l *forwardLog) put(docIDs []uint16, documents []*analyzedDocument) error {
+	records := make([]forwardRecord, len(docIDs))
+	for j, docID := range docIDs {
+		records[j] = forwardRecord{operation: forwardPut, docID: docID, document: documents[j]}
+	}
+	if err := l.write(records); err != nil {
+		return err
+	}
+	return l.sync()
+}
+
+func (l *forwardLog) delete(docID uint16) error {
+	return l.write([]forwardRecord{{operation: forwardDelete, docID: docID}})
+}
+
+func (l *forwardLog) write(records []forwardRecord) error {
+	if l == nil || len(records) == 0 {
+		return nil
+	}
+	var b []byte
+	for _, record := range records {
+		start := len(b)
+		b = append(b, make([]byte, forwardHeaderSize)...)
+		b = record.appendTo(b)
+		payload := b[start+forwardHeaderSize:]
+		binary.LittleEndian.PutUint32(b[start:], crc32.Checksum(payload, forwardChecksumTable))
+		binary.LittleEndian.PutUint32(b[start+4:], uint32(len(payload)))
+	}
+	if _, err := l.file.Write(b); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingForwardLog, err)
+	}
+	return nil
+}
+
+func (l *forwardLog) sync() error {
+	if l == nil {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingForwardLog, err)
+	}
+	return nil
+}
+
+func (l *forwardLog) close() error {
+	if l == nil {
+		return nil
+	}
+	if err := l.sync(); err != nil {
+		return err
+	}
+	return l.file.Close()
+}
+
+func (r *forwardRecord) appendTo(b []byte) []byte {
+	b = append(b, byte(r.operation))
+	b = binary.LittleEndian.AppendUint16(b, r.docID)
+	if r.operation != forwardPut {
+		return b
+	}
+	b = binary.LittleEndian.AppendUint64(b, r.document.createdBits)
+	b = binary.LittleEndian.AppendUint64(b, r.document.dieBits)
+	for _, strings := range [][]string{r.document.terms, r.document.keys} {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(strings)))
+		for _, s := range strings {
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+			b = append(b, s...)
+		}
+	}
+	return b
+}
+
+func forwardRecordFromBytes(b []byte) (forwardRecord, bool) {
+	if len(b) < 3 {
+		return forwardRecord{}, false
+	}
+	r := forwardRecord{operation: forwardOperation(b[0]), docID: binary.LittleEndian.Uint16(b[1:])}
+	b = b[3:]
+	switch r.operation {
+	case forwardDelete:
+		return r, len(b) == 0
+	case forwardPut:
+	default:
+		return forwardRecord{}, false
+	}
+
+	if len(b) < 16 {
+		return forwardRecord{}, false
+	}
+	r.document = &analyzedDocument{
+		createdBits: binary.LittleEndian.Uint64(b),
+		dieBits:     binary.LittleEndian.Uint64(b[8:]),
+	}
+	b = b[16:]
+	for _, strings := range []*[]string{&r.document.terms, &r.document.keys} {
+		if len(b) < 4 {
+			return forwardRecord{}, false
+		}
+		n := int(binary.LittleEndian.Uint32(b))
+		b = b[4:]
+		// every string takes at least its length
+		if n > len(b)/4 {
+			return forwardRecord{}, false
+		}
+		*strings = make([]string, n)
+		for j := range n {
+			if len(b) < 4 {
+				return forwardRecord{}, false
+			}
+			length := int(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+			if length > len(b) {
+				return forwardRecord{}, false
+			}
+			(*strings)[j] = string(b[:length])
+			b = b[length:]
+		}
+	}
+	return r, len(b) == 0
+}
@@ -1,38 +1,87 @@
 package inverted_index
 
 import (
-	"bufio"
-	"crypto/sha256"
-	"encoding/binary"
 	"errors"
 	"math"
-	"os"
-	"time"
+	"path/filepath"
+	"sync"
 
 	// lemmatization "github.com/aaaton/golem/v4"
 	// enDict "github.com/aaaton/golem/v4/dicts/en"
 	// "github.com/bbalet/stopwords"
-	"golang.org/x/example/hello/reverse"
 
 	"inverted-index/internal/btree"
+	document_store "inverted-index/internal/document-store"
+	"inverted-index/internal/lsm-tree/common"
 	"inverted-index/internal/lsm-tree/lsm_tree"
 	roaring_bitmap "inverted-index/internal/roaring-bitmap"
 )
 
+const (
+	documentStoreFile  = "documents"
+	maxDocumentsNumber = math.MaxUint16
+)
+
 var (
+	ErrDocumentNotFound         = errors.New("document not found")
 	ErrInvalidTerm              = errors.New("invalid term (stop-word?)")
+	ErrTooManyDocuments         = errors.New("too many documents")
 	ErrUnsupportedWildcardQuery = errors.New("wildcard queries with more than one * are not supported")
 )
 
 type InvertedIndex struct {
-	storage *lsm_tree.LSMTree
+	// mu makes document updates atomic for queries
+	mu sync.RWMutex
+	// forwardLog persists the forward index; indexes created with New have none
+	forwardLog *forwardLog
+	storage    *lsm_tree.LSMTree
 	// lemmatizer      *lemmatization.Lemmatizer
 	documentsNumber uint16
 	dict            *btree.BTree
 	reverseDict     *btree.BTree
+	// forwardIndex holds what was indexed for every live document, so it can be unindexed later
+	forwardIndex  map[uint16]*analyzedDocument
+	documentStore *document_store.DocumentStore
+	// ttl is the compaction filter of the storage if it is a TTL filter, which is told the die times of documents
+	ttl *lsm_tree.TTLFilter
+	// expired are the live documents the TTL filter expired, whose terms are out of the dictionaries,
+	// and forgotten the ones the storage dropped, which are still to be deleted
+	expired   map[uint16]struct{}
+	forgotten roaring_bitmap.Container
 }
 
+// New creates an empty index in the current directory, see NewWithOptions.
 func New() (*InvertedIndex, error) {
+	return NewWithOptions(lsm_tree.DefaultOptions())
+}
+
+// NewWithOptions creates an empty index keeping its files in storageOptions.Dir.
+// Unlike an index created with Open, it is not persisted. If storageOptions.CompactionFilter
+// is a TTL filter, all queries and Complete leave out the documents it expires, which are
+// dropped from the storage in time and deleted afterwards.
+func NewWithOptions(storageOptions lsm_tree.Options) (*InvertedIndex, error) {
+	storage, err := lsm_tree.New(storageOptions)
+	if err != nil {
+		return nil, err
+	}
+	if storageOptions.Dir == "" {
+		storageOptions.Dir = common.Dir
+	}
+	// the store is kept beside the data directory of the storage, which deletes the files it does not know
+	documentStore, err := document_store.New(filepath.Join(storageOptions.Dir, documentStoreFile))
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := newInvertedIndex(storage, documentStore)
+	if err != nil {
+		return nil, err
+	}
+	i.ttl, _ = storageOptions.CompactionFilter.(*lsm_tree.TTLFilter)
+	return i, nil
+}
+
+func newInvertedIndex(storage *lsm_tree.LSMTree, documentStore *document_store.DocumentStore) (*InvertedIndex, error) {
 	// l, err := lemmatization.New(enDict.New())
 	// if err != nil {
 	// 	return nil, err
@@ -48,65 +97,17 @@ func New() (*InvertedIndex, error) {
 	}
 
 	return &InvertedIndex{
-		storage: lsm_tree.New(),
+		storage: storage,
 		// lemmatizer:      l,
 		documentsNumber: 0,
 		dict:            dict,
 		reverseDict:     reverseDict,
+		forwardIndex:    make(map[uint16]*analyzedDocument),
+		documentStore:   documentStore,
+		expired:         make(map[uint16]struct{}),
 	}, nil
 }
 
-func (i *InvertedIndex) AddDocument(filePath string, createdTime time.Time, dieTime *time.Time) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanWords)
-
-	for scanner.Scan() {
-		toAdd, processedTerm := i.processTerm(scanner.Text())
-		if toAdd {
-			err = i.storage.Add(processedTerm, i.documentsNumber)
-			if err != nil {
-				return err
-			}
-
-			i.dict.Insert(scanner.Text())
-			i.reverseDict.Insert(reverse.String(scanner.Text()))
-		}
-	}
-
-	createdTimeUnix := createdTime.Unix()
-	dieTimeUnix := int64(math.MaxInt64)
-	if dieTime != nil {
-		dieTimeUnix = dieTime.Unix()
-	}
-
-	for j := 0; createdTimeUnix > 0 || dieTimeUnix > 0; j++ {
-		if createdTimeUnix&1 == 1 {
-			err = i.storage.Add(uint16(j), i.documentsNumber)
-			if err != nil {
-				return err
-			}
-		}
-		createdTimeUnix >>= 1
-
-		if dieTimeUnix&1 == 1 {
-			err = i.storage.Add(uint16(j+64), i.documentsNumber)
-			if err != nil {
-				return err
-			}
-		}
-		dieTimeUnix >>= 1
-	}
-
-	i.documentsNumber++
-	return scanner.Err()
-}
-
 func (i *InvertedIndex) ConvertFromContainer(c roaring_bitmap.Container) []int {
 	result := make([]int, 0)
 
@@ -134,21 +135,13 @@ func (i *InvertedIndex) ConvertFromContainer(c roaring_bitmap.Container) []int {
 	return result
 }
 
-func (i *InvertedIndex) processTerm(term string) (toAdd bool, processedFeature uint16) {
+func (i *InvertedIndex) processTerm(term string) (toAdd bool, processedFeature []byte) {
 	// term = strings.TrimSpace(stopwords.CleanString(term, "en", false))
 	// if len(term) == 0 {
-	// 	 return false, 0
+	// 	 return false, nil
 	// }
 
 	// lemma := i.lemmatizer.LemmaLower(term)
 
-	for {
-		hash := sha256.Sum256([]byte(term))
-		value := binary.BigEndian.Uint16(hash[:2])
-		if value >= 128 {
-			return true, value
-		}
-		// Rehashing for no intersection with date features
-		term += "salt"
-	}
+	return true, termKey(term)
 }
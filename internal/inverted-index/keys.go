@@ -0,0 +1,53 @@
+package inverted_index
+
+import (
+	"crypto/sha256"
+
+	"inverted-index/internal/lsm-tree/common"
+)
+
+// Storage keys start with the kind of the feature they index, so that terms
+// never collide with date features
+const (
+	termKeyPrefix        = 't'
+	longTermKeyPrefix    = 'h'
+	createdTimeKeyPrefix = 'c'
+	dieTimeKeyPrefix     = 'd'
+)
+
+// maxTermSize is the length of the longest term kept in its key as is
+const maxTermSize = common.MaxKeySize - 1
+
+// termKey stores terms too long for a key by their hash.
+func termKey(term string) []byte {
+	if len(term) > maxTermSize {
+		hash := sha256.Sum256([]byte(term))
+		return append([]byte{longTermKeyPrefix}, hash[:]...)
+	}
+	key := make([]byte, 0, 1+len(term))
+	key = append(key, termKeyPrefix)
+	return append(key, term...)
+}
+
+func dateKey(tmType timeType, bit int) []byte {
+	if tmType == dieTime {
+		return dieTimeKey(bit)
+	}
+	return createdTimeKey(bit)
+}
+
+var createdTimeKeys, dieTimeKeys = func() (created, died [64]string) {
+	for bit := range 64 {
+		created[bit] = string(createdTimeKey(bit))
+		died[bit] = string(dieTimeKey(bit))
+	}
+	return created, died
+}()
+
+func createdTimeKey(bit int) []byte {
+	return []byte{createdTimeKeyPrefix, byte(bit)}
+}
+
+func dieTimeKey(bit int) []byte {
+	return []byte{dieTimeKeyPrefix, byte(bit)}
+}
@@ -0,0 +1,95 @@
+package inverted_index
+
+import (
+	"runtime"
+	"sync"
+)
+
+// IndexParallel indexes documents tokenizing and analyzing them on workers goroutines,
+// GOMAXPROCS if workers is not positive. Analyzed documents are added to the index one
+// at a time by a single writer in their original order, so IDs follow the order of
+// documents and queries can run in between. On error the documents indexed so far stay
+// indexed and their IDs are returned along with the error.
+func (i *InvertedIndex) IndexParallel(documents []BatchDocument, workers int) ([]uint16, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	docIDs := make([]uint16, 0, len(documents))
+	err := i.analyzeDocuments(documents, workers, func(j int, document *analyzedDocument) error {
+		docID, err := i.indexDocument(document, documents[j].Meta)
+		if err != nil {
+			return err
+		}
+		docIDs = append(docIDs, docID)
+		return nil
+	})
+	return docIDs, err
+}
+
+type analysisResult struct {
+	idx      int
+	document *analyzedDocument
+	err      error
+}
+
+// analyzeDocuments passes the results to handle on the calling goroutine, in order.
+func (i *InvertedIndex) analyzeDocuments(documents []BatchDocument, workers int, handle func(j int, document *analyzedDocument) error) error {
+	workers = max(1, min(workers, len(documents)))
+
+	jobs := make(chan int)
+	results := make(chan analysisResult, workers)
+	// done stops the workers once the caller is not interested in the results anymore
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(jobs)
+		for j := range documents {
+			select {
+			case jobs <- j:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				document, err := i.analyzeDocument(documents[j].Reader, documents[j].Meta.CreatedTime, documents[j].Meta.DieTime)
+				select {
+				case results <- analysisResult{idx: j, document: document, err: err}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// results come in any order, so the ones ahead of the next expected are held back
+	pending := make(map[int]*analyzedDocument)
+	next := 0
+	for result := range results {
+		if result.err != nil {
+			return result.err
+		}
+		pending[result.idx] = result.document
+		for document, ok := pending[next]; ok; document, ok = pending[next] {
+			delete(pending, next)
+			if err := handle(next, document); err != nil {
+				return err
+			}
+			next++
+		}
+	}
+
+	return nil
+}
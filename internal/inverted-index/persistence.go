@@ -0,0 +1,111 @@
+package inverted_index
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/example/hello/reverse"
+
+	document_store "inverted-index/internal/document-store"
+	"inverted-index/internal/lsm-tree/lsm_tree"
+)
+
+const storageDir = "lsm"
+
+// Open restores the index kept in dir, or creates an empty one there. Every change is
+// written through as it is made, so an index that was not closed because its process
+// crashed is restored with all the documents added, updated or deleted before. After a
+// crash of the system, the latest changes the storage had not synced yet may be lost,
+// see lsm_tree.Options.WALSync, but the IDs they took are never given out again.
+func Open(dir string) (*InvertedIndex, error) {
+	return OpenWithOptions(dir, lsm_tree.DefaultOptions())
+}
+
+// OpenWithOptions is Open with the storage configured by storageOptions.
+// The storage is always kept in dir, whatever storageOptions.Dir is. A TTL filter
+// in storageOptions.CompactionFilter works as with NewWithOptions.
+func OpenWithOptions(dir string, storageOptions lsm_tree.Options) (*InvertedIndex, error) {
+	storageOptions.Dir = filepath.Join(dir, storageDir)
+	storage, err := lsm_tree.Open(storageOptions)
+	if err != nil {
+		return nil, err
+	}
+	documentStore, err := document_store.Open(filepath.Join(dir, documentStoreFile))
+	if err != nil {
+		return nil, err
+	}
+	i, err := newInvertedIndex(storage, documentStore)
+	if err != nil {
+		return nil, err
+	}
+	i.ttl, _ = storageOptions.CompactionFilter.(*lsm_tree.TTLFilter)
+
+	logPath := filepath.Join(dir, forwardLogFile)
+	records, err := readForwardLog(logPath)
+	if err != nil {
+		return nil, err
+	}
+	// IDs are never reused, so the next one follows the highest logged, deleted or not
+	next := 0
+	for _, record := range records {
+		if record.operation == forwardPut {
+			i.forwardIndex[record.docID] = record.document
+		} else {
+			delete(i.forwardIndex, record.docID)
+		}
+		next = max(next, int(record.docID)+1)
+	}
+	if next > maxDocumentsNumber {
+		return nil, fmt.Errorf("%w: %w", ErrReadingForwardLog, ErrTooManyDocuments)
+	}
+	i.documentsNumber = uint16(next)
+
+	// documents are committed by the document store, so the logged ones it does not hold
+	// were being added or deleted when the index was left; their postings are deleted
+	for docID := range i.forwardIndex {
+		if _, ok := documentStore.Get(docID); !ok {
+			if err = storage.Delete(docID); err != nil {
+				return nil, err
+			}
+			delete(i.forwardIndex, docID)
+		}
+	}
+
+	// the log is compacted to the live documents, keeping the highest ID taken
+	compacted := make([]forwardRecord, 0, len(i.forwardIndex)+1)
+	for docID, document := range i.forwardIndex {
+		compacted = append(compacted, forwardRecord{operation: forwardPut, docID: docID, document: document})
+
+		// the dictionaries hold the terms of live documents with their document frequencies,
+		// so they are rebuilt from the forward index instead of being logged
+		for _, term := range document.terms {
+			i.dict.AddFrequency(term, 1)
+			i.reverseDict.Insert(reverse.String(term))
+		}
+		storedDocument, _ := documentStore.Get(docID)
+		i.setDieTime(docID, storedDocument.DieTime)
+	}
+	if lastDocID := uint16(next - 1); next > 0 && i.forwardIndex[lastDocID] == nil {
+		compacted = append(compacted, forwardRecord{operation: forwardDelete, docID: lastDocID})
+	}
+	if i.forwardLog, err = createForwardLog(logPath, compacted); err != nil {
+		return nil, err
+	}
+	i.dropExpiredTerms()
+
+	return i, nil
+}
+
+// Close releases the files of the index. The index must not be used afterwards.
+func (i *InvertedIndex) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.forwardLog.close(); err != nil {
+		return err
+	}
+	if err := i.storage.Close(); err != nil {
+		return err
+	}
+	return i.documentStore.Close()
+}
@@ -8,6 +8,13 @@ import (
 )
 
 func (i *InvertedIndex) PreciseQuery(query string) (roaring_bitmap.Container, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.preciseQuery(query)
+}
+
+func (i *InvertedIndex) preciseQuery(query string) (roaring_bitmap.Container, error) {
 	if ok, processedTerm := i.processTerm(query); !ok {
 		return nil, ErrInvalidTerm
 	} else {
@@ -15,10 +22,23 @@ func (i *InvertedIndex) PreciseQuery(query string) (roaring_bitmap.Container, er
 	}
 }
 
+// Complete returns at most n indexed terms starting with prefix, the ones
+// contained in the largest number of documents first. Expired documents are not counted.
+func (i *InvertedIndex) Complete(prefix string, n int) []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.dropExpiredTerms()
+	return i.dict.Complete(prefix, n)
+}
+
 func (i *InvertedIndex) WildcardQuery(query string) (roaring_bitmap.Container, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	queryParts := strings.Split(query, "*")
 	if len(queryParts) == 1 {
-		return i.PreciseQuery(query)
+		return i.preciseQuery(query)
 	} else if len(queryParts) > 2 {
 		return nil, ErrUnsupportedWildcardQuery
 	}
@@ -60,7 +80,7 @@ func (i *InvertedIndex) WildcardQuery(query string) (roaring_bitmap.Container, e
 
 	var resultContainer roaring_bitmap.Container
 	for _, term := range resultTerms {
-		c, err := i.PreciseQuery(term)
+		c, err := i.preciseQuery(term)
 		if err != nil {
 			return nil, err
 		}
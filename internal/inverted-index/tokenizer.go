@@ -0,0 +1,62 @@
+package inverted_index
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Unlike bufio.Scanner, tokenizer has no limit on the length of a line or a term.
+type tokenizer struct {
+	reader *bufio.Reader
+	term   []byte
+}
+
+var readers sync.Pool
+
+func newTokenizer(r io.Reader) *tokenizer {
+	reader, ok := readers.Get().(*bufio.Reader)
+	if ok {
+		reader.Reset(r)
+	} else {
+		reader = bufio.NewReader(r)
+	}
+	return &tokenizer{reader: reader}
+}
+
+func (t *tokenizer) close() {
+	t.reader.Reset(nil)
+	readers.Put(t.reader)
+	t.reader = nil
+}
+
+func (t *tokenizer) next() (string, error) {
+	t.term = t.term[:0]
+
+	for {
+		r, size, err := t.reader.ReadRune()
+		if err == io.EOF && len(t.term) > 0 {
+			return string(t.term), nil
+		} else if err != nil {
+			return "", err
+		}
+
+		if unicode.IsSpace(r) {
+			if len(t.term) > 0 {
+				return string(t.term), nil
+			}
+			continue
+		}
+
+		if r == utf8.RuneError && size == 1 {
+			// keep invalid UTF-8 bytes as they are
+			_ = t.reader.UnreadRune()
+			b, _ := t.reader.ReadByte()
+			t.term = append(t.term, b)
+		} else {
+			t.term = utf8.AppendRune(t.term, r)
+		}
+	}
+}
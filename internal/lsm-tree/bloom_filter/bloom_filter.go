@@ -1,76 +1,117 @@
 package bloom_filter
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"hash"
-	"hash/fnv"
 	"math"
 
 	"github.com/bits-and-blooms/bitset"
 )
 
-const (
-	bitsNumber = 500000
-)
+// DefaultBitsPerKey gives about 1% false positives
+const DefaultBitsPerKey = 10
+
+// HashFNV64a is the only hash algorithm so far: FNV-1a, 64 bits, over the number of
+// the hash function followed by the element. It is recorded in encoded filters, so
+// that other algorithms can be added without breaking them.
+const HashFNV64a uint8 = 1
+
+// headerSize is the size of the parameters leading an encoded filter
+const headerSize = 1 + 1 + 4
+
+var ErrInvalidFilter = errors.New("invalid bloom filter")
 
 type BloomFilter interface {
 	Add(element []byte) error
 	CheckContains(element []byte) (bool, error)
+	// Bytes encodes the filter with its parameters, which FromBytes decodes
+	Bytes() []byte
 }
 
+// bloomFilter hashes without any state, so that concurrent searches can check it
+// together; only Add must not run concurrently with other calls.
 type bloomFilter struct {
-	hashFuncs []hash.Hash64
-	filter    *bitset.BitSet
+	// hashFuncsNumber is the number of bits set for every element
+	hashFuncsNumber int
+	filter          *bitset.BitSet
+	bitsNumber      uint
 }
 
-func New(elementsNumber int) *bloomFilter {
-	k := getOptimalHashFuncsNumber(elementsNumber)
-	bloomFilter := &bloomFilter{
-		hashFuncs: make([]hash.Hash64, k),
-		filter:    bitset.New(bitsNumber),
-	}
+// New creates a filter for elementsNumber elements taking bitsPerKey bits for each of them.
+func New(elementsNumber int, bitsPerKey int) *bloomFilter {
+	bitsNumber := uint(max(elementsNumber*bitsPerKey, 64))
+	return newBloomFilter(bitset.New(bitsNumber), bitsNumber, getOptimalHashFuncsNumber(bitsPerKey))
+}
 
-	for i := 0; i < k; i++ {
-		bloomFilter.hashFuncs[i] = fnv.New64a()
+// FromBytes decodes a filter encoded by Bytes. The filter is used with the parameters
+// it was created with, whatever the bits per key of new filters are.
+func FromBytes(b []byte) (*bloomFilter, error) {
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidFilter, len(b))
+	}
+	algorithm, k, bitsNumber := b[0], int(b[1]), uint(binary.LittleEndian.Uint32(b[2:]))
+	if algorithm != HashFNV64a {
+		return nil, fmt.Errorf("%w: unknown hash algorithm %d", ErrInvalidFilter, algorithm)
+	}
+	words := make([]uint64, (bitsNumber+63)/64)
+	if k == 0 || bitsNumber == 0 || len(b) != headerSize+len(words)*8 {
+		return nil, fmt.Errorf("%w: %d hash functions and %d bits in %d bytes", ErrInvalidFilter, k, bitsNumber, len(b))
 	}
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(b[headerSize+i*8:])
+	}
+	return newBloomFilter(bitset.From(words), bitsNumber, k), nil
+}
 
-	return bloomFilter
+func newBloomFilter(filter *bitset.BitSet, bitsNumber uint, k int) *bloomFilter {
+	return &bloomFilter{
+		hashFuncsNumber: k,
+		filter:          filter,
+		bitsNumber:      bitsNumber,
+	}
 }
 
 func (b *bloomFilter) Add(element []byte) error {
-	for _, h := range b.hashFuncs {
-		h.Reset()
-		_, err := h.Write(element)
-		if err != nil {
-			return fmt.Errorf("adding element to bloom filter: %w", err)
-		}
-
-		index := indexFromHash(h.Sum64())
-		b.filter.Set(index)
+	for i := range b.hashFuncsNumber {
+		b.filter.Set(b.index(i, element))
 	}
 	return nil
 }
 
 func (b *bloomFilter) CheckContains(element []byte) (bool, error) {
-	for _, h := range b.hashFuncs {
-		h.Reset()
-		_, err := h.Write(element)
-		if err != nil {
-			return false, fmt.Errorf("checking element in bloom filter: %w", err)
-		}
-
-		index := indexFromHash(h.Sum64())
-		if !b.filter.Test(index) {
+	for i := range b.hashFuncsNumber {
+		if !b.filter.Test(b.index(i, element)) {
 			return false, nil
 		}
 	}
 	return true, nil
 }
 
-func getOptimalHashFuncsNumber(elementsNumber int) int {
-	return int(math.Ceil(bitsNumber / float64(elementsNumber) * math.Ln2))
+// Bytes encodes the hash algorithm, the number of hash functions and of bits, and then the bits.
+func (b *bloomFilter) Bytes() []byte {
+	result := []byte{HashFNV64a, uint8(b.hashFuncsNumber)}
+	result = binary.LittleEndian.AppendUint32(result, uint32(b.bitsNumber))
+	for _, word := range b.filter.Bytes() {
+		result = binary.LittleEndian.AppendUint64(result, word)
+	}
+	return result
 }
 
-func indexFromHash(hash uint64) uint {
-	return uint(hash % uint64(bitsNumber))
+func getOptimalHashFuncsNumber(bitsPerKey int) int {
+	return min(max(int(math.Ceil(float64(bitsPerKey)*math.Ln2)), 1), math.MaxUint8)
+}
+
+const (
+	fnvOffset64 uint64 = 14695981039346656037
+	fnvPrime64  uint64 = 1099511628211
+)
+
+// index computes FNV-1a in place, since hash/fnv would allocate on every call.
+func (b *bloomFilter) index(i int, element []byte) uint {
+	h := (fnvOffset64 ^ uint64(byte(i))) * fnvPrime64
+	for _, c := range element {
+		h = (h ^ uint64(c)) * fnvPrime64
+	}
+	return uint(h % uint64(b.bitsNumber))
 }
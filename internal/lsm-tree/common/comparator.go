@@ -0,0 +1,25 @@
+package common
+
+import "bytes"
+
+// MaxKeySize is the length of the longest key, so that key lengths fit in two bytes on disk
+const MaxKeySize = 1<<16 - 1
+
+// Comparator orders keys. A tree has to be opened with the comparator it was written
+// with, which is checked by name.
+type Comparator interface {
+	// Compare returns a negative number if a < b, zero if a == b and a positive number if a > b
+	Compare(a []byte, b []byte) int
+	Name() string
+}
+
+// BytewiseComparator orders keys lexicographically by their bytes.
+type BytewiseComparator struct{}
+
+func (BytewiseComparator) Compare(a []byte, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (BytewiseComparator) Name() string {
+	return "bytewise"
+}
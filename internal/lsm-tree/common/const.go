@@ -1,8 +1,9 @@
 package common
 
+// Defaults of lsm_tree.Options; DataDir is relative to Dir.
 const (
 	MaxLevelSize   = 5
 	FirstLevelSize = 50000
+	Dir            = "."
 	DataDir        = "./data"
-	MetaDataDir    = "./metadata"
 )
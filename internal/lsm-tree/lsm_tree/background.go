@@ -0,0 +1,270 @@
+package lsm_tree
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"inverted-index/internal/lsm-tree/sstable"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+func (l *LSMTree) startBackgroundWork() {
+	l.backgroundDone = make(chan struct{})
+	go l.backgroundWork()
+}
+
+// backgroundWork still flushes the queued RAM components on close, so that their logs
+// need no replay, but leaves full levels as they are.
+func (l *LSMTree) backgroundWork() {
+	defer close(l.backgroundDone)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for {
+		// a manual compaction flushes and merges by itself meanwhile
+		for l.backgroundErr == nil && (l.manualCompaction || (!l.closing && !l.hasBackgroundWork())) {
+			l.background.Wait()
+		}
+		if l.backgroundErr != nil || (l.closing && len(l.immutable) == 0) {
+			return
+		}
+
+		// merges go first, so that levels do not grow past their limits and throttled
+		// writers are let through as soon as possible
+		if compaction := l.pickCompaction(); compaction != nil && !l.closing {
+			if err := l.compact(compaction); err != nil {
+				l.backgroundErr = fmt.Errorf("%w: %w", ErrMergingSSTables, err)
+			}
+		} else if err := l.flushImmutable(); err != nil {
+			l.backgroundErr = fmt.Errorf("%w: %w", ErrFlushingRAMComponent, err)
+		}
+		l.background.Broadcast()
+	}
+}
+
+func (l *LSMTree) hasBackgroundWork() bool {
+	return len(l.immutable) > 0 || l.pickCompaction() != nil
+}
+
+func (l *LSMTree) pickCompaction() *Compaction {
+	return l.options.CompactionStrategy.PickCompaction(l.sstables, l.options)
+}
+
+func (l *LSMTree) waitForBackgroundWork() error {
+	for l.backgroundErr == nil && !l.closing && l.hasBackgroundWork() {
+		l.background.Wait()
+	}
+	return l.backgroundErr
+}
+
+// Flush writes the RAM component to a table and waits until all flushes and merges are done.
+func (l *LSMTree) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.ramComponent) > 0 {
+		if err := l.freeze(); err != nil {
+			return err
+		}
+	}
+	return l.waitForBackgroundWork()
+}
+
+// flushImmutable writes the table without the lock, since the RAM component does not
+// change anymore and only the background worker changes the levels.
+func (l *LSMTree) flushImmutable() error {
+	m := l.immutable[0]
+	name := strconv.Itoa(l.newFileNumber())
+
+	l.mu.Unlock()
+	newSSTable, err := sstable.NewFromMap(
+		filepath.Join(l.dataDir, name),
+		m.values,
+		l.options.Comparator,
+		l.options.BloomBitsPerKey,
+		l.options.compression(0),
+	)
+	if err == nil {
+		l.mapTables(newSSTable)
+	}
+	l.mu.Lock()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCreatingSSTable, err)
+	}
+
+	// searches see the values either in the RAM component or in the table, never in both
+	l.sstables[0] = append(l.sstables[0], newSSTable)
+	l.immutable = l.immutable[1:]
+	l.flushedBytes += newSSTable.FileSize()
+
+	edit := l.newVersionEdit()
+	edit.Added = []tableEntry{{Level: 0, Name: newSSTable.Name()}}
+	if err = l.logEdit(edit); err != nil {
+		return err
+	}
+	if m.wal != nil {
+		// the new table and the log that replaced this one have to survive a crash first
+		if err = syncDir(l.dataDir); err != nil {
+			return fmt.Errorf("%w: %w", ErrCreatingSSTable, err)
+		}
+		if err = syncDir(l.dir); err != nil {
+			return fmt.Errorf("%w: %w", ErrWritingWAL, err)
+		}
+		return m.wal.remove()
+	}
+	return nil
+}
+
+// compact merges the tables of a compaction into tables of its output level. The merged tables
+// are written without the lock, against a snapshot of the deleted values, while searches
+// keep reading the old tables. A merge takes effect when it is logged to the manifest,
+// so if it is interrupted before, the new tables are removed on open, and if after, the
+// merged tables are.
+func (l *LSMTree) compact(c *Compaction) error {
+	deleted := l.snapshotDeleted()
+	expired := l.expired()
+	newPath := func() string {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		return filepath.Join(l.dataDir, strconv.Itoa(l.newFileNumber()))
+	}
+
+	l.mu.Unlock()
+	newSSTables, err := sstable.Merge(
+		newPath,
+		// the older tables of the next level go first
+		slices.Concat(c.Overlapping, c.Inputs),
+		c.MaxTableSize,
+		l.options.Comparator,
+		l.options.MergeOperator,
+		deleted.deleted,
+		l.filter(c.OutputLevel),
+		l.options.BloomBitsPerKey,
+		l.options.compression(c.OutputLevel),
+	)
+	l.mapTables(newSSTables...)
+	l.mu.Lock()
+	if err != nil {
+		return err
+	}
+
+	var edit versionEdit
+	for _, table := range c.Inputs {
+		edit.Removed = append(edit.Removed, tableEntry{Level: c.Level, Name: table.Name()})
+	}
+	for _, table := range c.Overlapping {
+		edit.Removed = append(edit.Removed, tableEntry{Level: c.OutputLevel, Name: table.Name()})
+	}
+	for _, table := range newSSTables {
+		edit.Added = append(edit.Added, tableEntry{Level: c.OutputLevel, Name: table.Name()})
+	}
+	if err = l.logEdit(edit); err != nil {
+		return err
+	}
+
+	if len(l.sstables) == c.OutputLevel {
+		l.sstables = append(l.sstables, make([]*sstable.SSTable, 0))
+	}
+	l.sstables[c.Level] = slices.DeleteFunc(l.sstables[c.Level], func(table *sstable.SSTable) bool {
+		return slices.Contains(c.Inputs, table)
+	})
+	l.sstables[c.OutputLevel] = slices.DeleteFunc(l.sstables[c.OutputLevel], func(table *sstable.SSTable) bool {
+		return slices.Contains(c.Overlapping, table)
+	})
+	l.sstables[c.OutputLevel] = append(l.sstables[c.OutputLevel], newSSTables...)
+	if c.OutputLevel > 0 && l.options.CompactionStrategy.NonOverlapping() {
+		l.sortByKey(l.sstables[c.OutputLevel])
+	}
+	l.compactions++
+	l.compactedBytes += levelSize(newSSTables)
+
+	if c.OutputLevel == len(l.sstables)-1 && l.dropMergedRemovals(deleted, newSSTables) {
+		if err = l.logEdit(l.newVersionEdit()); err != nil {
+			return err
+		}
+	}
+	if expired != nil && tablesNumber(l.sstables) == len(newSSTables) {
+		l.forgetExpired(expired)
+	}
+
+	// tables still read by iterators are deleted once they are released
+	for _, table := range slices.Concat(c.Inputs, c.Overlapping) {
+		if err = table.Remove(); err != nil {
+			return fmt.Errorf("%w: %w", ErrRemovingSSTable, err)
+		}
+	}
+	return nil
+}
+
+// dropMergedRemovals keeps the values removed again since the snapshot was taken.
+func (l *LSMTree) dropMergedRemovals(deleted deletedSnapshot, merged []*sstable.SSTable) bool {
+	dropped := false
+	for key, values := range deleted.removed {
+		if l.mayHold([]byte(key), merged) {
+			continue
+		}
+		if removed := roaring_bitmap.AndNot(l.removed[key], values); removed != nil {
+			l.removed[key] = removed
+		} else {
+			delete(l.removed, key)
+		}
+		dropped = true
+	}
+	return dropped
+}
+
+func (l *LSMTree) mayHold(key []byte, except []*sstable.SSTable) bool {
+	if _, ok := l.ramComponent[string(key)]; ok {
+		return true
+	}
+	for _, m := range l.immutable {
+		if _, ok := m.values[string(key)]; ok {
+			return true
+		}
+	}
+	for _, level := range l.sstables {
+		for _, table := range level {
+			if !slices.Contains(except, table) && mayContain(l.options.Comparator, table, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (l *LSMTree) sortByKey(tables []*sstable.SSTable) {
+	slices.SortFunc(tables, func(a *sstable.SSTable, b *sstable.SSTable) int {
+		return l.options.Comparator.Compare(a.Smallest(), b.Smallest())
+	})
+}
+
+func (l *LSMTree) mapTables(tables ...*sstable.SSTable) {
+	if !l.options.MemoryMap {
+		return
+	}
+	for _, table := range tables {
+		_ = table.Map()
+	}
+}
+
+type deletedSnapshot struct {
+	tombstones roaring_bitmap.Container
+	removed    map[string]roaring_bitmap.Container
+}
+
+// snapshotDeleted shares the containers, since they are replaced rather than modified.
+func (l *LSMTree) snapshotDeleted() deletedSnapshot {
+	return deletedSnapshot{
+		tombstones: l.tombstones,
+		removed:    maps.Clone(l.removed),
+	}
+}
+
+func (d deletedSnapshot) deleted(key []byte) roaring_bitmap.Container {
+	return roaring_bitmap.Or(d.tombstones, d.removed[string(key)])
+}
@@ -0,0 +1,135 @@
+package lsm_tree
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// waitIdle waits until the background worker has nothing left to flush or merge.
+func waitIdle(t *testing.T, l *LSMTree) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	require.NoError(t, l.waitForBackgroundWork())
+}
+
+func TestBackground_WritesThrottled(t *testing.T) {
+	l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 1, LevelFanOut: 2, L0StopWritesTrigger: 3})
+	require.NoError(t, err)
+	for key := range 200 {
+		require.NoError(t, l.Add(testKey(key), 1))
+		l.mu.RLock()
+		require.LessOrEqual(t, len(l.immutable)+len(l.sstables[0]), 3)
+		l.mu.RUnlock()
+	}
+
+	require.NoError(t, l.Flush())
+	require.Empty(t, l.immutable)
+	require.Less(t, len(l.sstables[0]), 2)
+	for key := range 200 {
+		require.Equal(t, []uint16{1}, values(t, l, testKey(key)))
+	}
+	require.NoError(t, l.Close())
+	require.ErrorIs(t, l.Add(testKey(0), 2), ErrClosed)
+}
+
+func TestBackground_RemovedDroppedByMerges(t *testing.T) {
+	options := Options{Dir: t.TempDir(), MemTableKeys: 10, LevelFanOut: 2}
+	l, err := Open(options)
+	require.NoError(t, err)
+	for key := range 10 {
+		require.NoError(t, l.Add(testKey(key), 1))
+	}
+	require.NoError(t, l.Remove(testKey(1), 1))
+	for key := range 10 {
+		require.NoError(t, l.Add(testKey(key), 2))
+	}
+	waitIdle(t, l)
+
+	// the merge into the last level applied the removal
+	require.Empty(t, l.sstables[0])
+	require.Len(t, l.sstables, 2)
+	require.Empty(t, l.removed)
+	require.Equal(t, []uint16{2}, values(t, l, testKey(1)))
+	require.NoError(t, l.Close())
+
+	l, err = Open(options)
+	require.NoError(t, err)
+	require.Empty(t, l.removed)
+	require.Equal(t, []uint16{2}, values(t, l, testKey(1)))
+	require.NoError(t, l.Close())
+}
+
+func TestBackground_SearchDuringMerges(t *testing.T) {
+	l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 10, LevelFanOut: 2})
+	require.NoError(t, err)
+
+	// every round adds its number to all keys while the tables are flushed and merged
+	var rounds atomic.Int32
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		for round := range 30 {
+			for key := range 50 {
+				if err := l.Add(testKey(key), uint16(round)); err != nil {
+					errs <- err
+					return
+				}
+			}
+			rounds.Store(int32(round + 1))
+		}
+	}()
+
+	for key := 0; rounds.Load() < 30; key = (key + 1) % 50 {
+		done := rounds.Load()
+		found := values(t, l, testKey(key))
+		for round := range done {
+			require.Contains(t, found, uint16(round))
+		}
+	}
+	require.NoError(t, <-errs)
+
+	require.NoError(t, l.Flush())
+	require.Greater(t, len(l.sstables), 2)
+	for key := range 50 {
+		require.Len(t, values(t, l, testKey(key)), 30)
+	}
+	require.NoError(t, l.Close())
+}
+
+func TestBackground_LaterWALsReplayed(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(Options{Dir: dir})
+	require.NoError(t, err)
+	require.NoError(t, l.Add(testKey(1), 1))
+	require.NoError(t, l.Close())
+
+	// the log of a RAM component started after the last flush before a crash
+	w, _, err := openWAL(l.walPath(l.fileCnt+10), SyncAlways, 0)
+	require.NoError(t, err)
+	require.NoError(t, w.append(
+		walRecord{operation: walAdd, key: testKey(1), values: []uint16{2}},
+		walRecord{operation: walRemove, key: testKey(1), values: []uint16{1}},
+	))
+	require.NoError(t, w.close())
+
+	for range 2 {
+		l, err = Open(Options{Dir: dir})
+		require.NoError(t, err)
+		require.Equal(t, []uint16{2}, values(t, l, testKey(1)))
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		wals := 0
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), walPrefix) {
+				wals++
+			}
+		}
+		require.Equal(t, 1, wals)
+		require.NoError(t, l.Close())
+	}
+}
@@ -0,0 +1,136 @@
+package lsm_tree
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+
+	"inverted-index/internal/lsm-tree/sstable"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+const (
+	defaultCacheSize = 8 << 20
+	// cacheShards split the cache, so that concurrent searches rarely wait for each other
+	cacheShards = 16
+	// cacheEntrySize approximates the memory taken by an entry besides its key and container
+	cacheEntrySize = 96
+)
+
+// containerCache needs no invalidation, since tables never change.
+type containerCache struct {
+	seed   maphash.Seed
+	shards [cacheShards]cacheShard
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cacheKey struct {
+	table string
+	key   string
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	value roaring_bitmap.Container
+	size  int
+}
+
+type cacheShard struct {
+	mu       sync.Mutex
+	capacity int
+	size     int
+	// entries are ordered from the most recently used to the least
+	entries *list.List
+	index   map[cacheKey]*list.Element
+}
+
+func newContainerCache(capacity int) *containerCache {
+	c := &containerCache{seed: maphash.MakeSeed()}
+	for i := range c.shards {
+		c.shards[i] = cacheShard{
+			capacity: capacity / cacheShards,
+			entries:  list.New(),
+			index:    make(map[cacheKey]*list.Element),
+		}
+	}
+	return c
+}
+
+func (c *containerCache) shard(key cacheKey) *cacheShard {
+	var h maphash.Hash
+	h.SetSeed(c.seed)
+	h.WriteString(key.table)
+	h.WriteString(key.key)
+	return &c.shards[h.Sum64()%cacheShards]
+}
+
+func (c *containerCache) get(key cacheKey) (roaring_bitmap.Container, bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.index[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	s.entries.MoveToFront(element)
+	return element.Value.(*cacheEntry).value, true
+}
+
+func (c *containerCache) put(key cacheKey, value roaring_bitmap.Container) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := &cacheEntry{key: key, value: value, size: cacheEntrySize + len(key.table) + len(key.key) + containerSize(value)}
+	if entry.size > s.capacity {
+		return
+	}
+	if element, ok := s.index[key]; ok {
+		s.remove(element)
+	}
+	s.index[key] = s.entries.PushFront(entry)
+	s.size += entry.size
+	for s.size > s.capacity {
+		s.remove(s.entries.Back())
+	}
+}
+
+func (s *cacheShard) remove(element *list.Element) {
+	entry := s.entries.Remove(element).(*cacheEntry)
+	delete(s.index, entry.key)
+	s.size -= entry.size
+}
+
+func (c *containerCache) size() int {
+	size := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		size += s.size
+		s.mu.Unlock()
+	}
+	return size
+}
+
+// searchTable does not cache the containers of mapped tables, which are read in place.
+func (l *LSMTree) searchTable(table *sstable.SSTable, key []byte) (*sstable.TableElement, error) {
+	if l.cache == nil || table.Mapped() {
+		return table.SearchKey(key)
+	}
+
+	k := cacheKey{table: table.Name(), key: string(key)}
+	if value, ok := l.cache.get(k); ok {
+		return &sstable.TableElement{Key: key, Value: value}, nil
+	}
+	element, err := table.SearchKey(key)
+	if err != nil || element == nil {
+		return nil, err
+	}
+	l.cache.put(k, element.Value)
+	return element, nil
+}
@@ -0,0 +1,75 @@
+package lsm_tree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 100})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, l.Close()) }()
+	for key := range 100 {
+		require.NoError(t, l.Add(testKey(key), 1))
+	}
+	require.NoError(t, l.Flush())
+
+	require.Equal(t, []uint16{1}, values(t, l, testKey(5)))
+	metrics := l.Metrics()
+	require.Equal(t, int64(0), metrics.CacheHits)
+	require.Equal(t, int64(1), metrics.CacheMisses)
+	require.Positive(t, metrics.CacheSize)
+
+	require.Equal(t, []uint16{1}, values(t, l, testKey(5)))
+	metrics = l.Metrics()
+	require.Equal(t, int64(1), metrics.CacheHits)
+	require.Equal(t, 0.5, metrics.CacheHitRatio())
+
+	// keys the table does not hold are not cached
+	require.Nil(t, values(t, l, []byte(string(testKey(5))+"0")))
+	require.Nil(t, values(t, l, []byte(string(testKey(5))+"0")))
+	require.Equal(t, int64(3), l.Metrics().CacheMisses)
+}
+
+func TestCache_Eviction(t *testing.T) {
+	// room for about one entry per shard
+	const size = cacheShards * 200
+	l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 100, CacheSize: size})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, l.Close()) }()
+	for key := range 100 {
+		require.NoError(t, l.Add(testKey(key), 1))
+	}
+	require.NoError(t, l.Flush())
+
+	for key := range 100 {
+		require.Equal(t, []uint16{1}, values(t, l, testKey(key)))
+	}
+	require.LessOrEqual(t, l.Metrics().CacheSize, size)
+
+	// the most recently used key is still cached, while the first ones were evicted
+	require.Equal(t, []uint16{1}, values(t, l, testKey(99)))
+	require.Equal(t, int64(1), l.Metrics().CacheHits)
+	for key := range 10 {
+		require.Equal(t, []uint16{1}, values(t, l, testKey(key)))
+	}
+	require.Less(t, l.Metrics().CacheHits, int64(11))
+}
+
+func TestCache_Disabled(t *testing.T) {
+	l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 100, CacheSize: -1})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, l.Close()) }()
+	for key := range 100 {
+		require.NoError(t, l.Add(testKey(key), 1))
+	}
+	require.NoError(t, l.Flush())
+
+	require.Equal(t, []uint16{1}, values(t, l, testKey(5)))
+	require.Equal(t, []uint16{1}, values(t, l, testKey(5)))
+	metrics := l.Metrics()
+	require.Zero(t, metrics.CacheHits)
+	require.Zero(t, metrics.CacheMisses)
+	require.Zero(t, metrics.CacheHitRatio())
+}
@@ -0,0 +1,217 @@
+package lsm_tree
+
+import (
+	"math"
+	"slices"
+
+	"inverted-index/internal/lsm-tree/common"
+	"inverted-index/internal/lsm-tree/sstable"
+)
+
+// CompactionStrategy picks the tables the background worker merges. Level 0 holds
+// the flushed RAM components, ordered from the oldest to the newest, and every next
+// level holds older tables than the previous one.
+type CompactionStrategy interface {
+	// Name identifies the strategy; a tree has to be reopened with the strategy it was written with
+	Name() string
+	// PickCompaction returns the next merge of levels, or nil if none is needed
+	PickCompaction(levels [][]*sstable.SSTable, options Options) *Compaction
+	// PickRangeCompaction returns the merge of the tables of level holding keys from start
+	// to end, both inclusive and open if nil, into the next level, or into level itself
+	// if it is the last one. It returns nil if there are no such tables.
+	PickRangeCompaction(levels [][]*sstable.SSTable, level int, start []byte, end []byte, options Options) *Compaction
+	// NonOverlapping tells if the tables of every level but level 0 have disjoint key
+	// ranges, in which case they are kept ordered by key rather than by age
+	NonOverlapping() bool
+}
+
+// Compaction merges tables of a level with the tables of the output level they overlap.
+// The merged tables replace all of them in the output level.
+type Compaction struct {
+	Level int
+	// OutputLevel is either the next level or, when the last level is rewritten, Level
+	OutputLevel int
+	// Inputs are the tables of Level to merge, ordered from the oldest to the newest
+	Inputs []*sstable.SSTable
+	// Overlapping are the tables of OutputLevel to merge, which are older than Inputs
+	Overlapping []*sstable.SSTable
+	// MaxTableSize is the data size in bytes at which the merged tables are split; zero means they are not
+	MaxTableSize int
+}
+
+// TieredCompaction merges all tables of a level into a single table of the next
+// level once the level holds LevelFanOut tables. Tables are written once per level,
+// but a key may be in every table of a level.
+type TieredCompaction struct{}
+
+func (TieredCompaction) Name() string {
+	return "tiered"
+}
+
+func (TieredCompaction) PickCompaction(levels [][]*sstable.SSTable, options Options) *Compaction {
+	for level := range levels {
+		if len(levels[level]) >= options.LevelFanOut {
+			return &Compaction{Level: level, OutputLevel: level + 1, Inputs: slices.Clone(levels[level])}
+		}
+	}
+	return nil
+}
+
+// PickRangeCompaction merges the whole level, since the tables left behind could be
+// older than the merged ones.
+func (TieredCompaction) PickRangeCompaction(levels [][]*sstable.SSTable, level int, start []byte, end []byte, options Options) *Compaction {
+	if len(overlapping(options.Comparator, levels[level], start, end)) == 0 {
+		return nil
+	}
+	return &Compaction{Level: level, OutputLevel: min(level+1, len(levels)-1), Inputs: slices.Clone(levels[level])}
+}
+
+func (TieredCompaction) NonOverlapping() bool {
+	return false
+}
+
+const (
+	defaultBaseLevelSize = 64 << 20
+	defaultTableSize     = 8 << 20
+)
+
+// LeveledCompaction keeps the tables of every level but level 0 sorted by key and not
+// overlapping, so that a search reads at most one table per level. Level 0 is merged
+// into level 1 once it holds LevelFanOut tables, and a level larger than its target
+// size has one of its tables merged into the next level. The target of level 1 is
+// BaseLevelSize, and every next level is LevelFanOut times larger.
+type LeveledCompaction struct {
+	// BaseLevelSize is the target size of level 1 in bytes; zero means 64 MiB
+	BaseLevelSize int
+	// TableSize is the data size in bytes at which merged tables are split; zero means 8 MiB
+	TableSize int
+}
+
+func (LeveledCompaction) Name() string {
+	return "leveled"
+}
+
+func (c LeveledCompaction) PickCompaction(levels [][]*sstable.SSTable, options Options) *Compaction {
+	if len(levels[0]) >= options.LevelFanOut {
+		return c.compaction(levels, 0, 1, slices.Clone(levels[0]), options)
+	}
+
+	// the level furthest over its target is merged first
+	level, bestScore := -1, 1.0
+	target := float64(c.BaseLevelSize)
+	if target == 0 {
+		target = defaultBaseLevelSize
+	}
+	for l := 1; l < len(levels); l++ {
+		if score := float64(levelSize(levels[l])) / target; score > bestScore {
+			level, bestScore = l, score
+		}
+		target *= float64(options.LevelFanOut)
+	}
+	if level < 0 {
+		return nil
+	}
+
+	// the table rewriting the fewest bytes of the next level for its own size is merged
+	var next []*sstable.SSTable
+	if level+1 < len(levels) {
+		next = levels[level+1]
+	}
+	var best *sstable.SSTable
+	bestRatio := math.Inf(1)
+	for _, table := range levels[level] {
+		if table.Smallest() == nil {
+			continue
+		}
+		overlaps := overlapping(options.Comparator, next, table.Smallest(), table.Largest())
+		if ratio := float64(levelSize(overlaps)) / float64(table.FileSize()); ratio < bestRatio {
+			best, bestRatio = table, ratio
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return c.compaction(levels, level, level+1, []*sstable.SSTable{best}, options)
+}
+
+// PickRangeCompaction merges the whole of level 0, whose tables overlap, and the tables of
+// any other level holding keys within the range.
+func (c LeveledCompaction) PickRangeCompaction(levels [][]*sstable.SSTable, level int, start []byte, end []byte, options Options) *Compaction {
+	inputs := overlapping(options.Comparator, levels[level], start, end)
+	if len(inputs) == 0 {
+		return nil
+	}
+	if level == 0 {
+		inputs = slices.Clone(levels[0])
+	}
+	return c.compaction(levels, level, min(level+1, len(levels)-1), inputs, options)
+}
+
+func (c LeveledCompaction) compaction(levels [][]*sstable.SSTable, level int, outputLevel int, inputs []*sstable.SSTable, options Options) *Compaction {
+	compaction := &Compaction{Level: level, OutputLevel: outputLevel, Inputs: inputs, MaxTableSize: c.TableSize}
+	if compaction.MaxTableSize == 0 {
+		compaction.MaxTableSize = defaultTableSize
+	}
+	if outputLevel != level && outputLevel < len(levels) {
+		if smallest, largest := keyRange(options.Comparator, inputs); smallest != nil {
+			compaction.Overlapping = overlapping(options.Comparator, levels[outputLevel], smallest, largest)
+		}
+	}
+	return compaction
+}
+
+func (LeveledCompaction) NonOverlapping() bool {
+	return true
+}
+
+func levelSize(tables []*sstable.SSTable) int {
+	size := 0
+	for _, table := range tables {
+		size += table.FileSize()
+	}
+	return size
+}
+
+func tablesNumber(levels [][]*sstable.SSTable) int {
+	n := 0
+	for _, level := range levels {
+		n += len(level)
+	}
+	return n
+}
+
+func keyRange(comparator common.Comparator, tables []*sstable.SSTable) ([]byte, []byte) {
+	var smallest, largest []byte
+	for _, table := range tables {
+		if table.Smallest() == nil {
+			continue
+		}
+		if smallest == nil || comparator.Compare(table.Smallest(), smallest) < 0 {
+			smallest = table.Smallest()
+		}
+		if largest == nil || comparator.Compare(table.Largest(), largest) > 0 {
+			largest = table.Largest()
+		}
+	}
+	return smallest, largest
+}
+
+// overlapping treats nil bounds as open; empty tables overlap nothing.
+func overlapping(comparator common.Comparator, tables []*sstable.SSTable, smallest []byte, largest []byte) []*sstable.SSTable {
+	var result []*sstable.SSTable
+	for _, table := range tables {
+		if table.Smallest() == nil {
+			continue
+		}
+		if (smallest == nil || comparator.Compare(table.Largest(), smallest) >= 0) &&
+			(largest == nil || comparator.Compare(table.Smallest(), largest) <= 0) {
+			result = append(result, table)
+		}
+	}
+	return result
+}
+
+func mayContain(comparator common.Comparator, table *sstable.SSTable, key []byte) bool {
+	return table.Smallest() != nil &&
+		comparator.Compare(key, table.Smallest()) >= 0 && comparator.Compare(key, table.Largest()) <= 0
+}
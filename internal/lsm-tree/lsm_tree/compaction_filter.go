@@ -0,0 +1,173 @@
+package lsm_tree
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// CompactionFilter rewrites values while tables are merged, e.g. to drop the expired ones.
+// A value filtered out of the merged tables may still be found in older tables until they
+// are merged too.
+type CompactionFilter interface {
+	// Filter returns what to keep of the merged value of key written to level, nil to drop the key
+	Filter(level int, key []byte, value roaring_bitmap.Container) roaring_bitmap.Container
+}
+
+func (l *LSMTree) filter(level int) func(key []byte, value roaring_bitmap.Container) roaring_bitmap.Container {
+	if l.options.CompactionFilter == nil {
+		return nil
+	}
+	return func(key []byte, value roaring_bitmap.Container) roaring_bitmap.Container {
+		return l.options.CompactionFilter.Filter(level, key, value)
+	}
+}
+
+func (l *LSMTree) expired() roaring_bitmap.Container {
+	if f, ok := l.options.CompactionFilter.(*TTLFilter); ok {
+		return f.Expired()
+	}
+	return nil
+}
+
+// forgetExpired keeps the values the RAM components still hold, which no merge dropped.
+func (l *LSMTree) forgetExpired(expired roaring_bitmap.Container) {
+	f, ok := l.options.CompactionFilter.(*TTLFilter)
+	if !ok {
+		return
+	}
+	components := []map[string]roaring_bitmap.Container{l.ramComponent}
+	for _, m := range l.immutable {
+		components = append(components, m.values)
+	}
+	dropped := slices.DeleteFunc(slices.Clone(expired.ConvertToArray().Values), func(value uint16) bool {
+		for _, component := range components {
+			for _, values := range component {
+				if values.Contains(value) {
+					return true
+				}
+			}
+		}
+		return false
+	})
+	if len(dropped) > 0 {
+		f.forget(dropped)
+	}
+}
+
+// TTLFilter is a CompactionFilter dropping values, e.g. document IDs, whose die time passed
+// more than a grace period ago. Searches and iterators leave the expired values out like
+// deleted ones, so they are not found in tables not merged yet either. Die times are set
+// by the owner of the values; it is safe to set them while the tree merges tables.
+type TTLFilter struct {
+	grace time.Duration
+	// now returns the current time
+	now func() time.Time
+
+	// mu guards the die times and the expired values computed from them
+	mu       sync.Mutex
+	dieTimes map[uint16]time.Time
+	// expired is recomputed once a die time changes, which makes it stale, or at nextExpiry,
+	// when the first of the values not expired yet expires
+	expired    roaring_bitmap.Container
+	stale      bool
+	nextExpiry time.Time
+	// forgotten are the values whose die times were dropped with them, see TakeForgotten
+	forgotten roaring_bitmap.Container
+}
+
+// NewTTLFilter returns a filter dropping values grace after their die time.
+func NewTTLFilter(grace time.Duration) *TTLFilter {
+	return &TTLFilter{
+		grace:    grace,
+		now:      time.Now,
+		dieTimes: make(map[uint16]time.Time),
+		stale:    true,
+	}
+}
+
+// SetDieTime makes value expire at dieTime.
+func (f *TTLFilter) SetDieTime(value uint16, dieTime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.dieTimes[value] = dieTime
+	f.forgotten = roaring_bitmap.AndNot(f.forgotten, singleValue(value))
+	f.stale = true
+}
+
+// ClearDieTime makes value live forever.
+func (f *TTLFilter) ClearDieTime(value uint16) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.dieTimes, value)
+	f.forgotten = roaring_bitmap.AndNot(f.forgotten, singleValue(value))
+	f.stale = true
+}
+
+// TakeForgotten returns the values whose die times the tree forgot since the last call,
+// or nil if there are none. A die time is forgotten once a merge writing all tables of the
+// tree dropped the expired value, so the tree holds the value nowhere anymore, unless its
+// die time is set or cleared again meanwhile.
+func (f *TTLFilter) TakeForgotten() roaring_bitmap.Container {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	forgotten := f.forgotten
+	f.forgotten = nil
+	return forgotten
+}
+
+func (f *TTLFilter) forget(values []uint16) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	var forgotten []uint16
+	for _, value := range values {
+		// a die time set again since the merge started is kept
+		if dieTime, ok := f.dieTimes[value]; ok && !now.Before(dieTime.Add(f.grace)) {
+			delete(f.dieTimes, value)
+			forgotten = append(forgotten, value)
+		}
+	}
+	f.forgotten = roaring_bitmap.Or(f.forgotten, roaring_bitmap.FromSortedValues(forgotten))
+	f.stale = true
+}
+
+// Expired returns the values whose die time passed more than the grace period ago, or nil if there are none.
+func (f *TTLFilter) Expired() roaring_bitmap.Container {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	if !f.stale && (f.nextExpiry.IsZero() || now.Before(f.nextExpiry)) {
+		return f.expired
+	}
+
+	var expired []uint16
+	f.nextExpiry = time.Time{}
+	for value, dieTime := range f.dieTimes {
+		if expiry := dieTime.Add(f.grace); !now.Before(expiry) {
+			expired = append(expired, value)
+		} else if f.nextExpiry.IsZero() || expiry.Before(f.nextExpiry) {
+			f.nextExpiry = expiry
+		}
+	}
+	slices.Sort(expired)
+	f.expired = roaring_bitmap.FromSortedValues(expired)
+	f.stale = false
+	return f.expired
+}
+
+// Filter drops the expired values from value.
+func (f *TTLFilter) Filter(_ int, _ []byte, value roaring_bitmap.Container) roaring_bitmap.Container {
+	expired := f.Expired()
+	if expired == nil {
+		return value
+	}
+	return roaring_bitmap.AndNot(value, expired)
+}
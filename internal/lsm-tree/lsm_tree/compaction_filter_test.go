@@ -0,0 +1,100 @@
+package lsm_tree
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// dropKeyFilter drops a key from the tables merged into a level.
+type dropKeyFilter struct {
+	key   []byte
+	level int
+}
+
+func (f dropKeyFilter) Filter(level int, key []byte, value roaring_bitmap.Container) roaring_bitmap.Container {
+	if level == f.level && bytes.Equal(key, f.key) {
+		return nil
+	}
+	return value
+}
+
+func TestCompactionFilter(t *testing.T) {
+	options := Options{Dir: t.TempDir(), MemTableKeys: 10, LevelFanOut: 3}
+	options.CompactionFilter = dropKeyFilter{key: testKey(7), level: 1}
+	l, err := New(options)
+	require.NoError(t, err)
+	for round := range 2 {
+		for key := range 10 {
+			require.NoError(t, l.Add(testKey(key), uint16(round)))
+		}
+	}
+	require.NoError(t, l.Flush())
+	require.Len(t, l.sstables[0], 2)
+	require.Equal(t, []uint16{0, 1}, values(t, l, testKey(7)))
+
+	// level 0 is merged into level 1
+	require.NoError(t, l.CompactAll(nil))
+	require.Len(t, l.sstables, 2)
+	require.Nil(t, values(t, l, testKey(7)))
+	require.Equal(t, []uint16{0, 1}, values(t, l, testKey(6)))
+	require.NoError(t, l.Close())
+}
+
+func TestTTLFilter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := NewTTLFilter(time.Hour)
+	filter.now = func() time.Time { return now }
+
+	filter.SetDieTime(1, now.Add(-2*time.Hour))
+	filter.SetDieTime(2, now.Add(-30*time.Minute))
+	filter.SetDieTime(3, now.Add(time.Hour))
+	require.Equal(t, []uint16{1}, filter.Expired().ConvertToArray().Values)
+
+	// the expired values are recomputed once the next of them expires
+	now = now.Add(30 * time.Minute)
+	require.Equal(t, []uint16{1, 2}, filter.Expired().ConvertToArray().Values)
+	filter.ClearDieTime(1)
+	require.Equal(t, []uint16{2}, filter.Expired().ConvertToArray().Values)
+
+	l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 10, LevelFanOut: 3, CompactionFilter: filter})
+	require.NoError(t, err)
+	for key := range 15 {
+		for value := range uint16(4) {
+			require.NoError(t, l.Add(testKey(key), value))
+		}
+	}
+	require.NoError(t, l.Add(testKey(20), 2))
+	// expired values are left out before the tables holding them are merged
+	require.Equal(t, []uint16{0, 1, 3}, values(t, l, testKey(0)))
+	require.Nil(t, values(t, l, testKey(20)))
+
+	require.NoError(t, l.CompactAll(nil))
+	for key := range 15 {
+		require.Equal(t, []uint16{0, 1, 3}, values(t, l, testKey(key)))
+	}
+	require.Nil(t, values(t, l, testKey(20)))
+	// no table holds the expired value anymore, so its die time is forgotten
+	require.Equal(t, []uint16{2}, filter.TakeForgotten().ConvertToArray().Values)
+	require.Nil(t, filter.TakeForgotten())
+
+	// values expiring after the compaction are left out at once and dropped by the next one
+	now = now.Add(2 * time.Hour)
+	require.Equal(t, []uint16{0, 1}, values(t, l, testKey(0)))
+	it := l.NewIterator(IteratorOptions{})
+	entries := scan(t, it)
+	require.Len(t, entries, 15)
+	for _, e := range entries {
+		require.Equal(t, []uint16{0, 1}, e.values)
+	}
+	require.NoError(t, it.Close())
+	require.NoError(t, l.CompactAll(nil))
+	require.Equal(t, []uint16{0, 1}, values(t, l, testKey(0)))
+	require.Equal(t, []uint16{3}, filter.TakeForgotten().ConvertToArray().Values)
+	require.Nil(t, filter.Expired())
+	require.NoError(t, l.Close())
+}
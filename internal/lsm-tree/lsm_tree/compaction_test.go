@@ -0,0 +1,90 @@
+package lsm_tree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompaction_Leveled(t *testing.T) {
+	dir := t.TempDir()
+	strategy := LeveledCompaction{BaseLevelSize: 4 << 10, TableSize: 1 << 10}
+	options := Options{Dir: dir, MemTableKeys: 100, LevelFanOut: 2, CompactionStrategy: strategy}
+	l, err := Open(options)
+	require.NoError(t, err)
+
+	// keys in scattered order, so that every flushed table overlaps the tables of every level
+	const keys = 2000
+	for round := range 3 {
+		for j := range keys {
+			require.NoError(t, l.Add(testKey(j*7919%keys), uint16(round)))
+		}
+	}
+	require.NoError(t, l.Flush())
+
+	checkLevels := func() {
+		require.Greater(t, len(l.sstables), 2)
+		require.Less(t, len(l.sstables[0]), 2)
+		target := strategy.BaseLevelSize
+		for level := 1; level < len(l.sstables); level++ {
+			tables := l.sstables[level]
+			for j := 1; j < len(tables); j++ {
+				require.Negative(t, l.options.Comparator.Compare(tables[j-1].Largest(), tables[j].Smallest()))
+			}
+			require.LessOrEqual(t, levelSize(tables), target)
+			target *= options.LevelFanOut
+		}
+	}
+	checkLevels()
+
+	metrics := l.Metrics()
+	require.Greater(t, metrics.Compactions, 0)
+	require.Greater(t, metrics.WriteAmplification(), 1.0)
+	for j := range keys {
+		require.Equal(t, []uint16{0, 1, 2}, values(t, l, testKey(j)))
+	}
+	// a search reads at most one table of every level but level 0
+	metrics = l.Metrics()
+	require.LessOrEqual(t, metrics.ReadAmplification(), float64(len(l.sstables)))
+	require.NoError(t, l.Close())
+
+	l, err = Open(options)
+	require.NoError(t, err)
+	checkLevels()
+	for j := range keys {
+		require.Equal(t, []uint16{0, 1, 2}, values(t, l, testKey(j)))
+	}
+	require.NoError(t, l.Close())
+
+	// tiered levels may overlap
+	options.CompactionStrategy = nil
+	_, err = Open(options)
+	require.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestCompaction_Metrics(t *testing.T) {
+	// tables large enough for their footers not to count
+	l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 1000, LevelFanOut: 2})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, l.Close()) }()
+	for key := range 4000 {
+		require.NoError(t, l.Add(testKey(key), 1))
+	}
+	require.NoError(t, l.Flush())
+
+	metrics := l.Metrics()
+	require.Equal(t, []LevelMetrics{
+		{Tables: 0, Size: 0},
+		{Tables: 0, Size: 0},
+		{Tables: 1, Size: l.sstables[2][0].FileSize()},
+	}, metrics.Levels)
+	require.Equal(t, 3, metrics.Compactions)
+	// every value is written once per level
+	require.InDelta(t, 3.0, metrics.WriteAmplification(), 0.01)
+
+	require.Equal(t, []uint16{1}, values(t, l, testKey(5)))
+	require.Nil(t, values(t, l, testKey(10000)))
+	metrics = l.Metrics()
+	require.Equal(t, int64(2), metrics.Searches)
+	require.Equal(t, 0.5, metrics.ReadAmplification())
+}
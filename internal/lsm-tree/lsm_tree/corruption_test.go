@@ -0,0 +1,77 @@
+package lsm_tree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"inverted-index/internal/lsm-tree/common"
+	"inverted-index/internal/lsm-tree/sstable"
+)
+
+// flipByte inverts the byte at offset of the file at path, from its end if offset is negative.
+func flipByte(t *testing.T, path string, offset int64) {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	if offset < 0 {
+		offset += int64(len(b))
+	}
+	b[offset] ^= 0xff
+	require.NoError(t, os.WriteFile(path, b, 0660))
+}
+
+func TestCorruption_DataBlock(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(Options{Dir: dir})
+	require.NoError(t, err)
+	flush(t, l, 0, 1)
+	waitIdle(t, l)
+	path := filepath.Join(dir, "data", l.sstables[0][0].Name())
+	require.NoError(t, l.Close())
+
+	corruptions, err := sstable.VerifyTable(path)
+	require.NoError(t, err)
+	require.Empty(t, corruptions)
+
+	// the first key is in the first block, which starts the file after 7 bytes of padding
+	// aligning its contents
+	flipByte(t, path, 10)
+	l, err = Open(Options{Dir: dir})
+	require.NoError(t, err)
+	_, err = l.Search(testKey(0))
+	require.ErrorIs(t, err, sstable.ErrCorruption)
+	// the other blocks are still read
+	require.Equal(t, []uint16{1}, values(t, l, testKey(common.FirstLevelSize-1)))
+	require.NoError(t, l.Close())
+
+	corruptions, err = sstable.VerifyTable(path)
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.Equal(t, int64(7), corruptions[0].Offset)
+	require.ErrorIs(t, corruptions[0], sstable.ErrCorruption)
+}
+
+func TestCorruption_Footer(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(Options{Dir: dir})
+	require.NoError(t, err)
+	flush(t, l, 0, 1)
+	waitIdle(t, l)
+	path := filepath.Join(dir, "data", l.sstables[0][0].Name())
+	require.NoError(t, l.Close())
+
+	// the first byte of the footer is the offset of the filter block
+	const footerSize = 40
+	flipByte(t, path, -footerSize)
+	_, err = Open(Options{Dir: dir})
+	require.ErrorIs(t, err, sstable.ErrCorruption)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	corruptions, err := sstable.VerifyTable(path)
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.Equal(t, info.Size()-footerSize, corruptions[0].Offset)
+}
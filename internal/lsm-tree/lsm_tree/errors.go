@@ -3,9 +3,20 @@ package lsm_tree
 import "errors"
 
 var (
+	ErrClosed               = errors.New("lsm tree closed")
 	ErrCreatingSSTable      = errors.New("error creating sstable")
 	ErrFlushingRAMComponent = errors.New("error flushing lsm tree RAM component")
+	ErrInvalidOptions       = errors.New("invalid lsm tree options")
+	ErrKeyTooLong           = errors.New("lsm tree key too long")
 	ErrMergingSSTables      = errors.New("error merging sstables")
+	ErrOpeningWAL           = errors.New("error opening write-ahead log")
+	ErrOpeningSSTable       = errors.New("error opening sstable")
+	ErrReadingManifest      = errors.New("error reading lsm tree manifest")
+	ErrReadingWAL           = errors.New("error reading write-ahead log")
+	ErrRemovingObsoleteFile = errors.New("error removing obsolete file")
 	ErrRemovingSSTable      = errors.New("error removing sstable")
 	ErrSearching            = errors.New("error searching sstable")
+	ErrSyncingWAL           = errors.New("error syncing write-ahead log")
+	ErrWritingManifest      = errors.New("error writing lsm tree manifest")
+	ErrWritingWAL           = errors.New("error writing write-ahead log")
 )
@@ -0,0 +1,271 @@
+package lsm_tree
+
+import (
+	"container/heap"
+	"fmt"
+	"maps"
+	"slices"
+
+	"inverted-index/internal/lsm-tree/common"
+	"inverted-index/internal/lsm-tree/merge_operator"
+	"inverted-index/internal/lsm-tree/sstable"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// IteratorOptions bound the keys an Iterator goes over; nil bounds are open.
+type IteratorOptions struct {
+	// LowerBound is the smallest key returned
+	LowerBound []byte
+	// UpperBound is the key all returned keys are less than
+	UpperBound []byte
+}
+
+// Iterator goes over the keys of a tree in the order of its comparator, returning
+// every key once with the value Search would return for it. It sees the tree as it
+// was when it was created and must be closed before the tree is.
+type Iterator struct {
+	options       IteratorOptions
+	comparator    common.Comparator
+	mergeOperator merge_operator.MergeOperator
+	deleted       deletedSnapshot
+	tables        []*sstable.SSTable
+	// sources are ordered from the oldest to the newest, the RAM component being the last
+	sources []iteratorSource
+	queue   sourceQueue
+
+	key   []byte
+	value roaring_bitmap.Container
+	err   error
+}
+
+type iteratorSource interface {
+	Seek(key []byte)
+	Next() bool
+	Element() *sstable.TableElement
+	Err() error
+}
+
+// NewIterator returns an iterator positioned before the first key within the bounds.
+func (l *LSMTree) NewIterator(options IteratorOptions) *Iterator {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	it := &Iterator{
+		options:       options,
+		comparator:    l.options.Comparator,
+		mergeOperator: l.options.MergeOperator,
+		deleted:       l.snapshotDeleted(),
+	}
+	// expired values are left out like deleted ones, but only by reads
+	it.deleted.tombstones = roaring_bitmap.Or(it.deleted.tombstones, l.expired())
+
+	for level := len(l.sstables) - 1; level >= 0; level-- {
+		for _, table := range l.sstables[level] {
+			// the table outlives a merge removing it until the iterator is closed
+			table.Ref()
+			it.tables = append(it.tables, table)
+			it.sources = append(it.sources, table.NewIterator())
+		}
+	}
+	for _, m := range l.immutable {
+		it.sources = append(it.sources, it.ramComponentSnapshot(m.values))
+	}
+	it.sources = append(it.sources, it.ramComponentSnapshot(l.ramComponent))
+
+	it.Seek(options.LowerBound)
+	return it
+}
+
+// Scan returns an iterator over the keys from start, inclusive, to end, exclusive.
+func (l *LSMTree) Scan(start []byte, end []byte) *Iterator {
+	return l.NewIterator(IteratorOptions{LowerBound: start, UpperBound: end})
+}
+
+func (it *Iterator) ramComponentSnapshot(ramComponent map[string]roaring_bitmap.Container) *ramComponentIterator {
+	keys := slices.Collect(maps.Keys(ramComponent))
+	keys = slices.DeleteFunc(keys, func(key string) bool {
+		return !it.withinBounds([]byte(key))
+	})
+	slices.SortFunc(keys, func(a string, b string) int {
+		return it.comparator.Compare([]byte(a), []byte(b))
+	})
+
+	elements := make([]sstable.TableElement, len(keys))
+	for j, key := range keys {
+		// the RAM containers keep changing after the lock is released, and merged
+		// values, which may be the containers themselves, are returned to the caller
+		elements[j] = sstable.TableElement{Key: []byte(key), Value: roaring_bitmap.Clone(ramComponent[key])}
+	}
+	return &ramComponentIterator{elements: elements, comparator: it.comparator}
+}
+
+func (it *Iterator) withinBounds(key []byte) bool {
+	if it.options.LowerBound != nil && it.comparator.Compare(key, it.options.LowerBound) < 0 {
+		return false
+	}
+	return it.options.UpperBound == nil || it.comparator.Compare(key, it.options.UpperBound) < 0
+}
+
+// Seek positions the iterator so that Next moves to the first key not less than
+// key, or than the lower bound if it is greater. A nil key moves to the first key.
+func (it *Iterator) Seek(key []byte) {
+	it.key, it.value = nil, nil
+	if it.err != nil {
+		return
+	}
+	if it.options.LowerBound != nil && (key == nil || it.comparator.Compare(key, it.options.LowerBound) < 0) {
+		key = it.options.LowerBound
+	}
+
+	it.queue = sourceQueue{comparator: it.comparator}
+	for age, source := range it.sources {
+		source.Seek(key)
+		if queued, ok := it.next(age); ok {
+			it.queue.items = append(it.queue.items, queued)
+		} else if it.err != nil {
+			return
+		}
+	}
+	heap.Init(&it.queue)
+}
+
+func (it *Iterator) next(age int) (queuedSource, bool) {
+	source := it.sources[age]
+	if source.Next() {
+		return queuedSource{element: source.Element(), age: age}, true
+	}
+	if err := source.Err(); err != nil {
+		it.err = fmt.Errorf("%w: %w", ErrSearching, err)
+	}
+	return queuedSource{}, false
+}
+
+// Next moves to the next key whose merged value is not empty, returning false
+// when there is none within the bounds or reading failed.
+func (it *Iterator) Next() bool {
+	it.key, it.value = nil, nil
+	for it.err == nil && it.queue.Len() > 0 {
+		key := it.queue.items[0].element.Key
+		if it.options.UpperBound != nil && it.comparator.Compare(key, it.options.UpperBound) >= 0 {
+			return false
+		}
+
+		// equal keys are popped from the oldest source to the newest
+		var operands []merge_operator.Operand
+		for it.queue.Len() > 0 && it.comparator.Compare(it.queue.items[0].element.Key, key) == 0 {
+			queued := heap.Pop(&it.queue).(queuedSource)
+			operands = append(operands, merge_operator.Operand{Value: queued.element.Value})
+			if next, ok := it.next(queued.age); ok {
+				heap.Push(&it.queue, next)
+			} else if it.err != nil {
+				return false
+			}
+		}
+		if deleted := it.deleted.deleted(key); deleted != nil {
+			operands = append(operands, merge_operator.Operand{Value: deleted, Tombstone: true})
+		}
+
+		// keys whose values were all deleted are skipped
+		if value := it.mergeOperator.FullMerge(key, operands); value != nil {
+			it.key, it.value = key, value
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns the key Next moved to.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the merged value of the key Next moved to.
+func (it *Iterator) Value() roaring_bitmap.Container {
+	return it.value
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the tables the iterator holds.
+func (it *Iterator) Close() error {
+	var err error
+	for _, table := range it.tables {
+		if unrefErr := table.Unref(); unrefErr != nil && err == nil {
+			err = fmt.Errorf("%w: %w", ErrRemovingSSTable, unrefErr)
+		}
+	}
+	it.tables = nil
+	it.queue.items = nil
+	return err
+}
+
+type ramComponentIterator struct {
+	elements   []sstable.TableElement
+	comparator common.Comparator
+	// idx is the index of the element returned by the next call of Next
+	idx int
+}
+
+func (r *ramComponentIterator) Seek(key []byte) {
+	if key == nil {
+		r.idx = 0
+		return
+	}
+	r.idx, _ = slices.BinarySearchFunc(r.elements, key, func(element sstable.TableElement, key []byte) int {
+		return r.comparator.Compare(element.Key, key)
+	})
+}
+
+func (r *ramComponentIterator) Next() bool {
+	if r.idx >= len(r.elements) {
+		return false
+	}
+	r.idx++
+	return true
+}
+
+func (r *ramComponentIterator) Element() *sstable.TableElement {
+	return &r.elements[r.idx-1]
+}
+
+func (r *ramComponentIterator) Err() error {
+	return nil
+}
+
+type queuedSource struct {
+	element *sstable.TableElement
+	// age is the index of the source, older sources having smaller ones
+	age int
+}
+
+type sourceQueue struct {
+	items      []queuedSource
+	comparator common.Comparator
+}
+
+func (q *sourceQueue) Len() int { return len(q.items) }
+
+func (q *sourceQueue) Less(i, j int) bool {
+	if c := q.comparator.Compare(q.items[i].element.Key, q.items[j].element.Key); c != 0 {
+		return c < 0
+	}
+	return q.items[i].age < q.items[j].age
+}
+
+func (q *sourceQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+}
+
+func (q *sourceQueue) Push(x interface{}) {
+	q.items = append(q.items, x.(queuedSource))
+}
+
+func (q *sourceQueue) Pop() interface{} {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items = q.items[:n-1]
+	return item
+}
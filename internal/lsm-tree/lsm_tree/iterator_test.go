@@ -0,0 +1,104 @@
+package lsm_tree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type entry struct {
+	key    []byte
+	values []uint16
+}
+
+func scan(t *testing.T, it *Iterator) []entry {
+	var entries []entry
+	for it.Next() {
+		entries = append(entries, entry{key: it.Key(), values: it.Value().ConvertToArray().Values})
+	}
+	require.NoError(t, it.Err())
+	return entries
+}
+
+func TestIterator(t *testing.T) {
+	l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 10, LevelFanOut: 2})
+	require.NoError(t, err)
+
+	// every key gets a value in each of several tables of different levels and in the RAM component
+	for round := range 5 {
+		for key := range 10 {
+			require.NoError(t, l.Add(testKey(key*10), uint16(round)))
+		}
+	}
+	require.NoError(t, l.Add(testKey(5), 7))
+	require.NoError(t, l.Add(testKey(95), 7))
+	waitIdle(t, l)
+	require.Greater(t, len(l.sstables), 2)
+	require.NotEmpty(t, l.ramComponent)
+
+	require.NoError(t, l.Remove(testKey(10), 0))
+	require.NoError(t, l.Delete(4))
+	require.NoError(t, l.Remove(testKey(5), 7))
+
+	it := l.NewIterator(IteratorOptions{})
+	entries := scan(t, it)
+	require.Len(t, entries, 11)
+	for j, e := range entries {
+		if j > 0 {
+			require.Negative(t, l.options.Comparator.Compare(entries[j-1].key, e.key))
+		}
+		require.Equal(t, values(t, l, e.key), e.values)
+	}
+	require.Equal(t, []uint16{1, 2, 3}, entries[1].values)
+	require.Equal(t, testKey(95), entries[10].key)
+
+	// bounds and seeks
+	it.Seek(testKey(35))
+	require.True(t, it.Next())
+	require.Equal(t, testKey(40), it.Key())
+	require.NoError(t, it.Close())
+
+	it = l.Scan(testKey(20), testKey(50))
+	entries = scan(t, it)
+	require.Len(t, entries, 3)
+	require.Equal(t, testKey(20), entries[0].key)
+	require.Equal(t, testKey(40), entries[2].key)
+	it.Seek(nil)
+	require.True(t, it.Next())
+	require.Equal(t, testKey(20), it.Key())
+	it.Seek(testKey(90))
+	require.False(t, it.Next())
+	require.NoError(t, it.Close())
+}
+
+func TestIterator_Snapshot(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(Options{Dir: dir, MemTableKeys: 10, LevelFanOut: 2})
+	require.NoError(t, err)
+	for key := range 15 {
+		require.NoError(t, l.Add(testKey(key), 1))
+	}
+	waitIdle(t, l)
+	table := l.sstables[0][0]
+
+	it := l.NewIterator(IteratorOptions{})
+	// the table of the iterator is merged and removed, and the RAM component changes
+	for key := range 15 {
+		require.NoError(t, l.Add(testKey(key), 2))
+	}
+	waitIdle(t, l)
+	require.NotContains(t, l.sstables[0], table)
+	require.FileExists(t, filepath.Join(dir, "data", table.Name()))
+
+	entries := scan(t, it)
+	require.Len(t, entries, 15)
+	for _, e := range entries {
+		require.Equal(t, []uint16{1}, e.values)
+	}
+	require.NoError(t, it.Close())
+	_, err = os.Stat(filepath.Join(dir, "data", table.Name()))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, l.Close())
+}
@@ -2,134 +2,418 @@ package lsm_tree
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
-	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"inverted-index/internal/lsm-tree/common"
+	"inverted-index/internal/lsm-tree/merge_operator"
 	"inverted-index/internal/lsm-tree/sstable"
 	roaring_bitmap "inverted-index/internal/roaring-bitmap"
 )
 
 type LSMTree struct {
-	sstables         [][]*sstable.SSTable
-	ramComponent     map[uint16]roaring_bitmap.Container
+	// mu lets searches run concurrently with each other, but not with modifications
+	mu      sync.RWMutex
+	options Options
+	// dir holds the manifest and the write-ahead log; trees created with New
+	// have none and are not persisted
+	dir      string
+	dataDir  string
+	sstables [][]*sstable.SSTable
+	// ramComponent and removed are keyed by the string of every key
+	ramComponent map[string]roaring_bitmap.Container
+	// ramComponentSize is the approximate size of the RAM component in bytes
 	ramComponentSize int
-	fileCnt          int
+	// immutable are full RAM components waiting to be flushed, from the oldest to the newest
+	immutable []*immutableRAMComponent
+	fileCnt   int
+	// tombstones are values deleted from every key and removed are values deleted
+	// from a single key; both are masked out on search and physically removed
+	// when sstables are merged, and removed values are forgotten once merged into the last level
+	tombstones roaring_bitmap.Container
+	removed    map[string]roaring_bitmap.Container
+	// wal logs the changes of the RAM component and manifest logs the changes of
+	// the sstables; trees created with New have neither
+	wal       *wal
+	walNumber int
+	manifest  *os.File
+
+	// background signals both the background worker that there is work to do and
+	// writers waiting for room that some work is done; it is tied to the write lock
+	background     *sync.Cond
+	backgroundErr  error
+	backgroundDone chan struct{}
+	closing        bool
+	// manualCompaction stops writers and the background worker while a manual compaction runs
+	manualCompaction bool
+
+	// flushedBytes, compactedBytes and compactions are changed by the background worker
+	// under the write lock, while searches count themselves under the read lock
+	flushedBytes   int
+	compactedBytes int
+	compactions    int
+	searches       atomic.Int64
+	tablesProbed   atomic.Int64
+
+	// cache keeps the containers searches read from the tables; it is nil if disabled
+	cache *containerCache
+}
+
+type immutableRAMComponent struct {
+	values    map[string]roaring_bitmap.Container
+	wal       *wal
+	walNumber int
+}
+
+// New creates an empty tree in options.Dir. Unlike a tree created with Open,
+// it is not persisted and overwrites the files left there before.
+func New(options Options) (*LSMTree, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	l := newLSMTree(options, false)
+	l.startBackgroundWork()
+	return l, nil
 }
 
-func New() *LSMTree {
-	return &LSMTree{
-		ramComponent: make(map[uint16]roaring_bitmap.Container),
+func newLSMTree(options Options, persistent bool) *LSMTree {
+	l := &LSMTree{
+		options:      options,
+		dataDir:      filepath.Join(options.Dir, common.DataDir),
+		ramComponent: make(map[string]roaring_bitmap.Container),
 		sstables:     make([][]*sstable.SSTable, 1),
+		removed:      make(map[string]roaring_bitmap.Container),
+	}
+	l.background = sync.NewCond(&l.mu)
+	if options.CacheSize > 0 {
+		l.cache = newContainerCache(options.CacheSize)
+	}
+	if persistent {
+		l.dir = options.Dir
 	}
+	return l
 }
 
-func (l *LSMTree) Add(key uint16, value uint16) error {
-	if _, ok := l.ramComponent[key]; !ok {
-		l.ramComponent[key] = &roaring_bitmap.Array{
-			Cardinality: 0,
-			Values:      []uint16{value},
-		}
-		l.ramComponentSize++
-	} else {
-		ok = l.ramComponent[key].Add(value)
-		if ok {
-			l.ramComponentSize++
-		}
+func (l *LSMTree) Add(key []byte, value uint16) error {
+	if err := checkKey(key); err != nil {
+		return err
 	}
 
-	if len(l.ramComponent) == common.FirstLevelSize {
-		err := l.flushRAMComponent()
-		if err != nil {
-			return fmt.Errorf("%w: %w", ErrFlushingRAMComponent, err)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.waitForRoom(); err != nil {
+		return err
+	}
+	if err := l.log(walRecord{operation: walAdd, key: key, values: []uint16{value}}); err != nil {
+		return err
+	}
+	l.add(key, value)
+	return l.freezeIfFull()
+}
+
+func (l *LSMTree) add(key []byte, value uint16) {
+	if removed, ok := l.removed[string(key)]; ok && removed.Contains(value) {
+		l.removed[string(key)] = roaring_bitmap.AndNot(removed, singleValue(value))
+		if l.removed[string(key)] == nil {
+			delete(l.removed, string(key))
 		}
 	}
 
-	return nil
+	c, ok := l.ramComponent[string(key)]
+	if !ok {
+		l.ramComponent[string(key)] = singleValue(value)
+		l.ramComponentSize += ramComponentKeySize + len(key) + containerSize(l.ramComponent[string(key)])
+		return
+	}
+	switch l.options.MergeOperator.(type) {
+	case merge_operator.Union, merge_operator.TombstoneAwareUnion:
+		// set unions do not need a new container for every value
+		sizeBefore := containerSize(c)
+		c.Add(value)
+		l.ramComponentSize += containerSize(c) - sizeBefore
+	default:
+		l.mergeIntoRAMComponent(key, c, singleValue(value))
+	}
 }
 
-func (l *LSMTree) Search(key uint16) (roaring_bitmap.Container, error) {
-	if rb, ok := l.ramComponent[key]; ok {
-		return rb, nil
+// AddContainers adds all values of every container to the container of its key,
+// filling up the RAM component at most once. values are keyed by the string of every key.
+func (l *LSMTree) AddContainers(values map[string]roaring_bitmap.Container) error {
+	for key := range values {
+		if err := checkKey([]byte(key)); err != nil {
+			return err
+		}
 	}
 
-	for level := range len(l.sstables) {
-		for i := len(l.sstables[level]) - 1; i >= 0; i-- {
-			searchResult, err := l.sstables[level][i].SearchKey(key)
-			if err != nil {
-				return nil, fmt.Errorf("%w: %w", ErrSearching, err)
-			}
-			if searchResult != nil {
-				return searchResult.Value, nil
-			}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.waitForRoom(); err != nil {
+		return err
+	}
+	if l.wal != nil {
+		records := make([]walRecord, 0, len(values))
+		for key, value := range values {
+			records = append(records, walRecord{operation: walAddValues, key: []byte(key), values: value.ConvertToArray().Values})
+		}
+		if err := l.log(records...); err != nil {
+			return err
 		}
 	}
 
-	return nil, nil
+	for key, value := range values {
+		l.addContainer([]byte(key), value)
+	}
+	return l.freezeIfFull()
 }
 
-func (l *LSMTree) Clear() {
-	for level := range l.sstables {
-		for _, sst := range l.sstables[level] {
-			_ = sst.Remove()
+func (l *LSMTree) addContainer(key []byte, value roaring_bitmap.Container) {
+	if removed, ok := l.removed[string(key)]; ok {
+		l.removed[string(key)] = roaring_bitmap.AndNot(removed, value)
+		if l.removed[string(key)] == nil {
+			delete(l.removed, string(key))
 		}
 	}
+
+	c, ok := l.ramComponent[string(key)]
+	if !ok {
+		l.ramComponent[string(key)] = value
+		l.ramComponentSize += ramComponentKeySize + len(key) + containerSize(value)
+		return
+	}
+	l.mergeIntoRAMComponent(key, c, value)
 }
 
-func (l *LSMTree) flushRAMComponent() error {
-	newSSTable, err := sstable.NewFromMap(
-		filepath.Join(common.MetaDataDir, strconv.Itoa(l.fileCnt)),
-		filepath.Join(common.DataDir, strconv.Itoa(l.fileCnt)),
-		l.ramComponent,
-	)
-	if err != nil {
-		return fmt.Errorf("%w: %w", ErrCreatingSSTable, err)
+func (l *LSMTree) mergeIntoRAMComponent(key []byte, c roaring_bitmap.Container, value roaring_bitmap.Container) {
+	merged := l.options.MergeOperator.PartialMerge(
+		key,
+		merge_operator.Operand{Value: c},
+		merge_operator.Operand{Value: value},
+	).Value
+	l.ramComponentSize += containerSize(merged) - containerSize(c)
+	if merged == nil {
+		delete(l.ramComponent, string(key))
+		l.ramComponentSize -= ramComponentKeySize + len(key)
+		return
 	}
+	l.ramComponent[string(key)] = merged
+}
+
+// ramComponentKeySize approximates the memory taken by a key of the RAM component besides its bytes and container
+const ramComponentKeySize = 32
 
-	l.sstables[0] = append(l.sstables[0], newSSTable)
-	l.fileCnt++
-	l.ramComponent = make(map[uint16]roaring_bitmap.Container)
+func containerSize(c roaring_bitmap.Container) int {
+	switch c := c.(type) {
+	case *roaring_bitmap.Array:
+		return 2 * len(c.Values)
+	case *roaring_bitmap.Bitmap:
+		return roaring_bitmap.BitmapWordsSize * 8
+	case *roaring_bitmap.Run:
+		return 4 * len(c.Values)
+	}
+	return 0
+}
+
+func (l *LSMTree) freezeIfFull() error {
+	if len(l.ramComponent) < l.options.MemTableKeys && l.ramComponentSize < l.options.MemTableSize {
+		return nil
+	}
+	return l.freeze()
+}
+
+// freeze queues the RAM component to be flushed and starts a new one, logged to a new
+// write-ahead log, so that the old log can be removed once the flush is done.
+func (l *LSMTree) freeze() error {
+	l.immutable = append(l.immutable, &immutableRAMComponent{
+		values:    l.ramComponent,
+		wal:       l.wal,
+		walNumber: l.walNumber,
+	})
+	l.ramComponent = make(map[string]roaring_bitmap.Container)
 	l.ramComponentSize = 0
+	l.background.Broadcast()
 
-	err = l.mergeSSTables()
-	if err != nil {
-		return fmt.Errorf("%w: %w", ErrMergingSSTables, err)
+	if l.wal != nil {
+		var err error
+		l.walNumber = l.newFileNumber()
+		l.wal, _, err = openWAL(l.walPath(l.walNumber), l.options.WALSync, l.options.WALSyncInterval)
+		if err != nil {
+			// changes must not be accepted without a log
+			l.backgroundErr = fmt.Errorf("%w: %w", ErrFlushingRAMComponent, err)
+			return l.backgroundErr
+		}
+	}
+	return nil
+}
+
+// waitForRoom counts the RAM components waiting to be flushed as level 0 tables.
+func (l *LSMTree) waitForRoom() error {
+	for l.backgroundErr == nil && !l.closing &&
+		(l.manualCompaction || len(l.immutable)+len(l.sstables[0]) >= l.options.L0StopWritesTrigger) {
+		l.background.Wait()
+	}
+	if l.closing {
+		return ErrClosed
 	}
+	return l.backgroundErr
+}
+
+// Remove removes value from the container of key. A later Add of the same pair restores it.
+func (l *LSMTree) Remove(key []byte, value uint16) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.waitForRoom(); err != nil {
+		return err
+	}
+	if err := l.log(walRecord{operation: walRemove, key: key, values: []uint16{value}}); err != nil {
+		return err
+	}
+	l.remove(key, value)
+	return nil
+}
+
+func (l *LSMTree) remove(key []byte, value uint16) {
+	l.removed[string(key)] = roaring_bitmap.Or(l.removed[string(key)], singleValue(value))
+}
 
+// Delete removes value from the containers of all keys.
+func (l *LSMTree) Delete(value uint16) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.waitForRoom(); err != nil {
+		return err
+	}
+	if err := l.log(walRecord{operation: walDelete, values: []uint16{value}}); err != nil {
+		return err
+	}
+	l.delete(value)
 	return nil
 }
 
-func (l *LSMTree) mergeSSTables() error {
-	for level := 0; level < len(l.sstables); level++ {
-		if len(l.sstables[level]) == common.MaxLevelSize {
-			newSSTable, err := sstable.New(
-				filepath.Join(common.MetaDataDir, strconv.Itoa(l.fileCnt)),
-				filepath.Join(common.DataDir, strconv.Itoa(l.fileCnt)),
-				l.sstables[level],
-			)
+func (l *LSMTree) delete(value uint16) {
+	l.tombstones = roaring_bitmap.Or(l.tombstones, singleValue(value))
+}
+
+// log fails once a background flush or merge failed, since the tree cannot take more changes.
+func (l *LSMTree) log(records ...walRecord) error {
+	if l.backgroundErr != nil {
+		return l.backgroundErr
+	}
+	if l.wal == nil {
+		return nil
+	}
+	return l.wal.append(records...)
+}
+
+func (l *LSMTree) Tombstones() roaring_bitmap.Container {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.tombstones
+}
+
+// Search returns the value of key. Values added at different times may be spread
+// over the RAM components and tables of any level, so all of them are merged with
+// the merge operator, from the oldest to the newest, followed by the deleted values.
+// The value may be shared with the cache and other searches, so it must not be modified.
+func (l *LSMTree) Search(key []byte) (value roaring_bitmap.Container, err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	l.searches.Add(1)
+	var operands []merge_operator.Operand
+	// views are the elements of mapped tables, whose containers refer to the mapped files
+	// until they are released once merged
+	var views []*sstable.TableElement
+	defer func() {
+		for _, element := range views {
+			if releaseErr := element.Release(); releaseErr != nil && err == nil {
+				value, err = nil, fmt.Errorf("%w: %w", ErrRemovingSSTable, releaseErr)
+			}
+		}
+	}()
+	for level := len(l.sstables) - 1; level >= 0; level-- {
+		for _, table := range l.sstables[level] {
+			// tables without the key are skipped by their key ranges and bloom filters
+			if !mayContain(l.options.Comparator, table, key) {
+				continue
+			}
+			l.tablesProbed.Add(1)
+			element, err := l.searchTable(table, key)
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("%w: %w", ErrSearching, err)
 			}
-
-			for _, table := range l.sstables[level] {
-				err = table.Remove()
-				if err != nil {
-					return fmt.Errorf("%w: %w", ErrRemovingSSTable, err)
-				}
+			if element == nil {
+				continue
 			}
-			l.sstables[level] = make([]*sstable.SSTable, 0)
-
-			if level == len(l.sstables)-1 {
-				l.sstables = l.sstables[:level+1]
+			if table.Mapped() {
+				views = append(views, element)
 			}
+			operands = append(operands, merge_operator.Operand{Value: element.Value})
+		}
+	}
+	for _, m := range l.immutable {
+		if rb, ok := m.values[string(key)]; ok {
+			// the merged value may be the container itself, which is still being flushed
+			operands = append(operands, merge_operator.Operand{Value: roaring_bitmap.Clone(rb)})
+		}
+	}
+	if rb, ok := l.ramComponent[string(key)]; ok {
+		// the RAM container keeps changing after the lock is released
+		operands = append(operands, merge_operator.Operand{Value: roaring_bitmap.Clone(rb)})
+	}
+	if deleted := roaring_bitmap.Or(l.deleted(key), l.expired()); deleted != nil {
+		operands = append(operands, merge_operator.Operand{Value: deleted, Tombstone: true})
+	}
 
-			l.fileCnt++
-			if len(l.sstables) == level+1 {
-				l.sstables = append(l.sstables, make([]*sstable.SSTable, 0))
-			}
-			l.sstables[level+1] = append(l.sstables[level+1], newSSTable)
+	if len(operands) == 0 {
+		return nil, nil
+	}
+	value = l.options.MergeOperator.FullMerge(key, operands)
+	for _, element := range views {
+		// the merged value may be a view itself, which outlives the element
+		if value == element.Value {
+			return roaring_bitmap.Clone(value), nil
 		}
 	}
+	return value, nil
+}
+
+func (l *LSMTree) deleted(key []byte) roaring_bitmap.Container {
+	return roaring_bitmap.Or(l.tombstones, l.removed[string(key)])
+}
 
+func checkKey(key []byte) error {
+	if len(key) > common.MaxKeySize {
+		return fmt.Errorf("%w: %d bytes long", ErrKeyTooLong, len(key))
+	}
 	return nil
 }
+
+func singleValue(value uint16) roaring_bitmap.Container {
+	return &roaring_bitmap.Array{
+		Cardinality: 0,
+		Values:      []uint16{value},
+	}
+}
+
+func (l *LSMTree) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for level := range l.sstables {
+		for _, sst := range l.sstables[level] {
+			_ = sst.Remove()
+		}
+	}
+}
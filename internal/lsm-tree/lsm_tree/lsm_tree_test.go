@@ -0,0 +1,68 @@
+package lsm_tree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"inverted-index/internal/lsm-tree/common"
+)
+
+// reverseComparator orders keys from the largest to the smallest.
+type reverseComparator struct{}
+
+func (reverseComparator) Compare(a []byte, b []byte) int {
+	return bytes.Compare(b, a)
+}
+
+func (reverseComparator) Name() string {
+	return "reverse"
+}
+
+func TestLSMTree_VariableLengthKeys(t *testing.T) {
+	for _, comparator := range []common.Comparator{common.BytewiseComparator{}, reverseComparator{}} {
+		dir := t.TempDir()
+		options := Options{Dir: dir, MemTableKeys: 100, LevelFanOut: 2, Comparator: comparator}
+		l, err := Open(options)
+		require.NoError(t, err)
+
+		// keys prefixing each other and keys of every length, spread over tables of several levels
+		var keys [][]byte
+		for j := range 1000 {
+			keys = append(keys, []byte(fmt.Sprintf("term:%d", j)), bytes.Repeat([]byte{byte(j)}, j%300))
+		}
+		for j, key := range keys {
+			require.NoError(t, l.Add(key, uint16(j)))
+		}
+		waitIdle(t, l)
+		require.Greater(t, len(l.sstables), 2)
+		require.NoError(t, l.Close())
+
+		l, err = Open(options)
+		require.NoError(t, err)
+		for j, key := range keys {
+			// the repeated-byte keys of the same byte and length are equal
+			require.Contains(t, values(t, l, key), uint16(j), comparator.Name())
+		}
+		require.Nil(t, values(t, l, []byte("term:")))
+		require.NoError(t, l.Close())
+
+		if _, ok := comparator.(reverseComparator); ok {
+			// the keys of the tables are not in the order of the default comparator
+			options.Comparator = nil
+			_, err = Open(options)
+			require.ErrorIs(t, err, ErrInvalidOptions)
+		}
+	}
+}
+
+func TestLSMTree_KeyTooLong(t *testing.T) {
+	l, err := New(Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, l.Close()) }()
+	require.NoError(t, l.Add(make([]byte, common.MaxKeySize), 1))
+	require.ErrorIs(t, l.Add(make([]byte, common.MaxKeySize+1), 1), ErrKeyTooLong)
+	require.Equal(t, []uint16{1}, values(t, l, make([]byte, common.MaxKeySize)))
+}
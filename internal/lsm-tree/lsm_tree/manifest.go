@@ -0,0 +1,486 @@
+package lsm_tree
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"inverted-index/internal/lsm-tree/sstable"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+const (
+	// currentFile holds the name of the manifest in use
+	currentFile    = "CURRENT"
+	manifestPrefix = "MANIFEST-"
+	walPrefix      = "WAL-"
+	tmpSuffix      = ".tmp"
+)
+
+// versionEdit is a record of the manifest; replaying the edits in order gives the durable state of the tree.
+type versionEdit struct {
+	Added   []tableEntry `json:"added,omitempty"`
+	Removed []tableEntry `json:"removed,omitempty"`
+	// NextFile is the number the next created file gets
+	NextFile int `json:"next_file"`
+	// WALNumber is the number of the oldest write-ahead log holding changes that are not in
+	// sstables; the changes are in it and in every later log
+	WALNumber int `json:"wal_number"`
+	// Deleted replaces the values deleted from the tree, if set
+	Deleted *deletedValues `json:"deleted,omitempty"`
+	// Comparator is the name of the comparator ordering the keys of the sstables, if set
+	Comparator string `json:"comparator,omitempty"`
+	// CompactionStrategy is the name of the strategy the levels are arranged by, if set
+	CompactionStrategy string `json:"compaction_strategy,omitempty"`
+}
+
+type tableEntry struct {
+	Level int    `json:"level"`
+	Name  string `json:"name"`
+}
+
+type deletedValues struct {
+	Tombstones []uint16       `json:"tombstones,omitempty"`
+	Removed    []removedValue `json:"removed,omitempty"`
+}
+
+// removedValue is needed since keys are arbitrary bytes, not valid JSON object keys.
+type removedValue struct {
+	Key    []byte   `json:"key"`
+	Values []uint16 `json:"values"`
+}
+
+type version struct {
+	levels     [][]string
+	nextFile   int
+	walNumber  int
+	deleted    deletedValues
+	comparator string
+	// compactionStrategy is the name of the strategy the levels are arranged by
+	compactionStrategy string
+}
+
+func (v *version) apply(edit *versionEdit) {
+	for _, entry := range edit.Removed {
+		v.levels[entry.Level] = slices.DeleteFunc(v.levels[entry.Level], func(name string) bool {
+			return name == entry.Name
+		})
+	}
+	for _, entry := range edit.Added {
+		for len(v.levels) <= entry.Level {
+			v.levels = append(v.levels, nil)
+		}
+		v.levels[entry.Level] = append(v.levels[entry.Level], entry.Name)
+	}
+	v.nextFile = edit.NextFile
+	v.walNumber = edit.WALNumber
+	if edit.Deleted != nil {
+		v.deleted = *edit.Deleted
+	}
+	if edit.Comparator != "" {
+		v.comparator = edit.Comparator
+	}
+	if edit.CompactionStrategy != "" {
+		v.compactionStrategy = edit.CompactionStrategy
+	}
+}
+
+// Open restores the tree kept in options.Dir, or creates an empty one there. Sstables are
+// listed in the manifest, and the RAM component is rebuilt from the write-ahead logs.
+// Files left behind by an interrupted flush or merge are removed.
+func Open(options Options) (*LSMTree, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(options.Dir, 0770); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingManifest, err)
+	}
+	l := newLSMTree(options, true)
+
+	v, err := readVersion(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	if v.comparator != "" && v.comparator != options.Comparator.Name() {
+		return nil, fmt.Errorf("%w: the tree was written with comparator %q, not %q",
+			ErrInvalidOptions, v.comparator, options.Comparator.Name())
+	}
+	if v.compactionStrategy != "" && v.compactionStrategy != options.CompactionStrategy.Name() {
+		return nil, fmt.Errorf("%w: the tree was written with compaction strategy %q, not %q",
+			ErrInvalidOptions, v.compactionStrategy, options.CompactionStrategy.Name())
+	}
+
+	l.fileCnt = v.nextFile
+	l.sstables = make([][]*sstable.SSTable, max(len(v.levels), 1))
+	for level, names := range v.levels {
+		for _, name := range names {
+			table, err := sstable.Open(filepath.Join(l.dataDir, name), options.Comparator)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrOpeningSSTable, err)
+			}
+			l.mapTables(table)
+			l.sstables[level] = append(l.sstables[level], table)
+		}
+		// the manifest lists the tables in the order they were added
+		if level > 0 && options.CompactionStrategy.NonOverlapping() {
+			l.sortByKey(l.sstables[level])
+		}
+	}
+	l.tombstones = roaring_bitmap.FromSortedValues(v.deleted.Tombstones)
+	for _, removed := range v.deleted.Removed {
+		l.removed[string(removed.Key)] = roaring_bitmap.FromSortedValues(removed.Values)
+	}
+
+	l.walNumber = v.walNumber
+	if l.walNumber == 0 {
+		l.walNumber = l.newFileNumber()
+	}
+	var records []walRecord
+	l.wal, records, err = openWAL(l.walPath(l.walNumber), options.WALSync, options.WALSyncInterval)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.gatherLaterWALs(&records); err != nil {
+		return nil, err
+	}
+
+	// the manifest is rewritten with a single edit, so that it does not grow across restarts
+	if err = l.writeManifest(); err != nil {
+		return nil, err
+	}
+	if err = l.removeObsoleteFiles(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range records {
+		l.apply(r)
+	}
+	if err = l.freezeIfFull(); err != nil {
+		return nil, err
+	}
+
+	l.startBackgroundWork()
+	return l, nil
+}
+
+// gatherLaterWALs appends to records those of the logs later than the current one, left by
+// RAM components that were waiting to be flushed on a crash, and moves all of them to a
+// new log, as they are replayed to a single RAM component. A crash before the manifest
+// refers to the new log replays the records twice, which leaves the same state.
+func (l *LSMTree) gatherLaterWALs(records *[]walRecord) error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrOpeningWAL, err)
+	}
+	var numbers []int
+	for _, entry := range entries {
+		number, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), walPrefix))
+		if strings.HasPrefix(entry.Name(), walPrefix) && err == nil && number > l.walNumber {
+			numbers = append(numbers, number)
+		}
+	}
+	if len(numbers) == 0 {
+		return nil
+	}
+	slices.Sort(numbers)
+
+	for _, number := range numbers {
+		w, walRecords, err := openWAL(l.walPath(number), SyncNever, 0)
+		if err != nil {
+			return err
+		}
+		if err = w.close(); err != nil {
+			return err
+		}
+		*records = append(*records, walRecords...)
+	}
+
+	if err = l.wal.close(); err != nil {
+		return err
+	}
+	l.walNumber = l.newFileNumber()
+	l.wal, _, err = openWAL(l.walPath(l.walNumber), l.options.WALSync, l.options.WALSyncInterval)
+	if err != nil {
+		return err
+	}
+	if err = l.wal.append(*records...); err != nil {
+		return err
+	}
+	return l.wal.flush()
+}
+
+func (l *LSMTree) apply(r walRecord) {
+	switch r.operation {
+	case walAdd:
+		l.add(r.key, r.values[0])
+	case walAddValues:
+		l.addContainer(r.key, roaring_bitmap.FromSortedValues(r.values))
+	case walRemove:
+		l.remove(r.key, r.values[0])
+	case walDelete:
+		l.delete(r.values[0])
+	}
+}
+
+// Close waits for the queued RAM components to be flushed, syncs the write-ahead log,
+// if the tree was opened with Open, and closes all files. Merges in progress are finished,
+// but full levels are left to be merged after the tree is reopened, and a manual compaction
+// stops after its current merge. The tree must not be used afterwards.
+func (l *LSMTree) Close() error {
+	l.mu.Lock()
+	l.closing = true
+	l.background.Broadcast()
+	l.mu.Unlock()
+	<-l.backgroundDone
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// RAM components are only left after a failed flush; their logs are replayed on open
+	for _, m := range l.immutable {
+		if m.wal != nil {
+			if err := m.wal.close(); err != nil {
+				return err
+			}
+		}
+	}
+	if l.wal != nil {
+		if err := l.wal.close(); err != nil {
+			return err
+		}
+	}
+	if l.manifest != nil {
+		if err := l.manifest.Close(); err != nil {
+			return err
+		}
+	}
+
+	for level := range l.sstables {
+		for _, table := range l.sstables[level] {
+			if err := table.Close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return l.backgroundErr
+}
+
+func readVersion(dir string) (*version, error) {
+	// file number 0 is left unused, so that a zero WALNumber means there is no log yet
+	v := &version{nextFile: 1}
+
+	current, err := os.ReadFile(filepath.Join(dir, currentFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return v, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingManifest, err)
+	}
+
+	file, err := os.Open(filepath.Join(dir, strings.TrimSpace(string(current))))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingManifest, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a torn last edit was never applied
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadingManifest, err)
+		}
+
+		var edit versionEdit
+		if err = json.Unmarshal(line, &edit); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadingManifest, err)
+		}
+		v.apply(&edit)
+	}
+
+	return v, nil
+}
+
+// writeManifest starts a new manifest holding the current state of the tree as a single edit
+// and makes it current. The switch is atomic, so a crash leaves either the old or the new manifest.
+func (l *LSMTree) writeManifest() error {
+	edit := l.newVersionEdit()
+	edit.Comparator = l.options.Comparator.Name()
+	edit.CompactionStrategy = l.options.CompactionStrategy.Name()
+	for level := range l.sstables {
+		for _, table := range l.sstables[level] {
+			edit.Added = append(edit.Added, tableEntry{Level: level, Name: table.Name()})
+		}
+	}
+
+	name := manifestPrefix + strconv.Itoa(l.newFileNumber())
+	edit.NextFile = l.fileCnt
+	file, err := os.OpenFile(filepath.Join(l.dir, name), os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0660)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingManifest, err)
+	}
+	if err = appendEdit(file, &edit); err != nil {
+		file.Close()
+		return err
+	}
+	if err = writeFileAtomically(filepath.Join(l.dir, currentFile), []byte(name+"\n")); err != nil {
+		file.Close()
+		return fmt.Errorf("%w: %w", ErrWritingManifest, err)
+	}
+
+	if l.manifest != nil {
+		if err = l.manifest.Close(); err != nil {
+			return fmt.Errorf("%w: %w", ErrWritingManifest, err)
+		}
+	}
+	l.manifest = file
+	return nil
+}
+
+func (l *LSMTree) logEdit(edit versionEdit) error {
+	if l.manifest == nil {
+		return nil
+	}
+	edit.NextFile = l.fileCnt
+	edit.WALNumber = l.oldestWALNumber()
+	return appendEdit(l.manifest, &edit)
+}
+
+func (l *LSMTree) oldestWALNumber() int {
+	if len(l.immutable) > 0 {
+		return l.immutable[0].walNumber
+	}
+	return l.walNumber
+}
+
+func (l *LSMTree) newVersionEdit() versionEdit {
+	edit := versionEdit{
+		NextFile:  l.fileCnt,
+		WALNumber: l.oldestWALNumber(),
+		Deleted: &deletedValues{
+			Removed: make([]removedValue, 0, len(l.removed)),
+		},
+	}
+	if l.tombstones != nil {
+		edit.Deleted.Tombstones = l.tombstones.ConvertToArray().Values
+	}
+	for key, values := range l.removed {
+		edit.Deleted.Removed = append(edit.Deleted.Removed, removedValue{
+			Key:    []byte(key),
+			Values: values.ConvertToArray().Values,
+		})
+	}
+	return edit
+}
+
+func appendEdit(file *os.File, edit *versionEdit) error {
+	line, err := json.Marshal(edit)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingManifest, err)
+	}
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingManifest, err)
+	}
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingManifest, err)
+	}
+	return nil
+}
+
+// removeObsoleteFiles removes the files the current manifest does not refer to:
+// sstables of an interrupted flush or merge, the tables replaced by a finished merge,
+// old manifests and write-ahead logs.
+func (l *LSMTree) removeObsoleteFiles() error {
+	live := make(map[string]struct{})
+	for level := range l.sstables {
+		for _, table := range l.sstables[level] {
+			live[table.Name()] = struct{}{}
+		}
+	}
+	entries, err := os.ReadDir(l.dataDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrRemovingObsoleteFile, err)
+	}
+	for _, entry := range entries {
+		if _, ok := live[entry.Name()]; !ok {
+			if err = os.Remove(filepath.Join(l.dataDir, entry.Name())); err != nil {
+				return fmt.Errorf("%w: %w", ErrRemovingObsoleteFile, err)
+			}
+		}
+	}
+
+	entries, err = os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRemovingObsoleteFile, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		obsolete := strings.HasSuffix(name, tmpSuffix) ||
+			(strings.HasPrefix(name, manifestPrefix) && name != l.manifestName()) ||
+			(strings.HasPrefix(name, walPrefix) && name != filepath.Base(l.walPath(l.walNumber)))
+		if obsolete {
+			if err = os.Remove(filepath.Join(l.dir, name)); err != nil {
+				return fmt.Errorf("%w: %w", ErrRemovingObsoleteFile, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *LSMTree) manifestName() string {
+	return filepath.Base(l.manifest.Name())
+}
+
+func (l *LSMTree) walPath(number int) string {
+	return filepath.Join(l.dir, walPrefix+strconv.Itoa(number))
+}
+
+func (l *LSMTree) newFileNumber() int {
+	l.fileCnt++
+	return l.fileCnt - 1
+}
+
+// writeFileAtomically replaces the file at path with a durable new version,
+// so that a crash leaves either the old or the new one.
+func writeFileAtomically(path string, b []byte) error {
+	tmpPath := path + tmpSuffix
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(b); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,165 @@
+package lsm_tree
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"inverted-index/internal/lsm-tree/common"
+	"inverted-index/internal/lsm-tree/sstable"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// flush fills the RAM component with value for FirstLevelSize keys starting from firstKey.
+func flush(t *testing.T, l *LSMTree, firstKey int, value uint16) {
+	containers := make(map[string]roaring_bitmap.Container, common.FirstLevelSize)
+	for key := firstKey; key < firstKey+common.FirstLevelSize; key++ {
+		containers[string(testKey(key))] = roaring_bitmap.FromSortedValues([]uint16{value})
+	}
+	require.NoError(t, l.AddContainers(containers))
+}
+
+func TestManifest_Reopen(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(Options{Dir: dir})
+	require.NoError(t, err)
+	for value := range common.MaxLevelSize + 1 {
+		flush(t, l, value, uint16(value))
+	}
+	require.NoError(t, l.Add(testKey(60000), 7))
+	waitIdle(t, l)
+	require.Len(t, l.sstables[0], 1)
+	require.Len(t, l.sstables[1], 1)
+	require.NoError(t, l.Close())
+
+	l, err = Open(Options{Dir: dir})
+	require.NoError(t, err)
+	require.Len(t, l.sstables[0], 1)
+	require.Len(t, l.sstables[1], 1)
+	require.Equal(t, []uint16{7}, values(t, l, testKey(60000)))
+	require.Equal(t, []uint16{0, 1, 2}, values(t, l, testKey(2)))
+	require.Equal(t, []uint16{5}, values(t, l, testKey(50004)))
+
+	// new files must not overwrite the existing ones
+	flush(t, l, 0, 100)
+	require.NoError(t, l.Close())
+
+	l, err = Open(Options{Dir: dir})
+	require.NoError(t, err)
+	require.Len(t, l.sstables[0], 2)
+	require.Len(t, l.sstables[1], 1)
+	require.Equal(t, []uint16{0, 100}, values(t, l, testKey(0)))
+	require.Equal(t, []uint16{5}, values(t, l, testKey(50004)))
+	require.Equal(t, []uint16{7}, values(t, l, testKey(60000)))
+	require.NoError(t, l.Close())
+}
+
+func TestManifest_ObsoleteFilesRemoved(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(Options{Dir: dir})
+	require.NoError(t, err)
+	flush(t, l, 0, 1)
+	waitIdle(t, l)
+	name := l.sstables[0][0].Name()
+	require.NoError(t, l.Close())
+
+	// a table of an interrupted merge or flush that never made it to the manifest
+	b, err := os.ReadFile(filepath.Join(dir, "data", name))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data", "999"), b, 0660))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, currentFile+tmpSuffix), nil, 0660))
+
+	l, err = Open(Options{Dir: dir})
+	require.NoError(t, err)
+	require.NoFileExists(t, filepath.Join(dir, "data", "999"))
+	require.NoFileExists(t, filepath.Join(dir, currentFile+tmpSuffix))
+	require.FileExists(t, filepath.Join(dir, "data", name))
+	require.Len(t, l.sstables[0], 1)
+	require.Equal(t, []uint16{1}, values(t, l, testKey(0)))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	manifests := 0
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), manifestPrefix) {
+			manifests++
+		}
+	}
+	require.Equal(t, 1, manifests)
+	require.NoError(t, l.Close())
+}
+
+func TestManifest_TornEdit(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(Options{Dir: dir})
+	require.NoError(t, err)
+	flush(t, l, 0, 1)
+	manifestPath := l.manifest.Name()
+	require.NoError(t, l.Close())
+
+	file, err := os.OpenFile(manifestPath, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.Write([]byte(`{"added":[{"level":0,"name":"12`))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	l, err = Open(Options{Dir: dir})
+	require.NoError(t, err)
+	require.Len(t, l.sstables[0], 1)
+	require.Equal(t, []uint16{1}, values(t, l, testKey(0)))
+	require.NoError(t, l.Close())
+}
+
+func TestManifest_UnknownTableFormat(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(Options{Dir: dir})
+	require.NoError(t, err)
+	flush(t, l, 0, 1)
+	waitIdle(t, l)
+	table := l.sstables[0][0]
+	// a table of FirstLevelSize keys spans many blocks, each read on its own
+	require.Greater(t, table.FileSize(), 16<<10)
+	for _, key := range []int{0, 1, common.FirstLevelSize / 2, common.FirstLevelSize - 1} {
+		require.Equal(t, []uint16{1}, values(t, l, testKey(key)))
+	}
+	path := filepath.Join(dir, "data", table.Name())
+	require.NoError(t, l.Close())
+
+	// the footer ends with the magic number
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.WriteAt([]byte{0}, info.Size()-1)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	_, err = Open(Options{Dir: dir})
+	require.ErrorIs(t, err, ErrOpeningSSTable)
+	require.ErrorIs(t, err, sstable.ErrUnknownFormat)
+}
+
+func TestManifest_BloomFilterParameters(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(Options{Dir: dir, BloomBitsPerKey: 4})
+	require.NoError(t, err)
+	flush(t, l, 0, 1)
+	require.NoError(t, l.Close())
+
+	// the filters of the tables written before keep their parameters
+	l, err = Open(Options{Dir: dir, BloomBitsPerKey: 20})
+	require.NoError(t, err)
+	for key := range common.FirstLevelSize {
+		require.Equal(t, []uint16{1}, values(t, l, testKey(key)))
+	}
+	require.Nil(t, values(t, l, testKey(common.FirstLevelSize)))
+	require.NoError(t, l.Close())
+}
@@ -0,0 +1,98 @@
+package lsm_tree
+
+import (
+	"fmt"
+
+	"inverted-index/internal/lsm-tree/sstable"
+)
+
+// CompactionProgress describes a manual compaction after each of its steps, one per level.
+type CompactionProgress struct {
+	// Level is the level whose tables the step merged
+	Level int
+	// Step is the number of steps done out of Steps
+	Step  int
+	Steps int
+	// BytesWritten is the size of the tables written so far
+	BytesWritten int
+}
+
+// CompactAll merges all tables into the last level, as CompactRange does with open bounds.
+func (l *LSMTree) CompactAll(progress func(CompactionProgress)) error {
+	return l.CompactRange(nil, nil, progress)
+}
+
+// CompactRange flushes the RAM component and merges the tables holding keys from start
+// to end, both inclusive and open if nil, level by level down to the last level. The
+// values removed from the keys within the range are dropped afterwards, since no table
+// holds them anymore; values deleted from all keys are kept, as Tombstones reports them.
+// Searches and iterators run meanwhile, but writers wait until the compaction is done.
+// progress, if not nil, is called after every step without the lock held.
+func (l *LSMTree) CompactRange(start []byte, end []byte, progress func(CompactionProgress)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// merges in progress and other manual compactions go first
+	for l.backgroundErr == nil && !l.closing && (l.manualCompaction || l.hasBackgroundWork()) {
+		l.background.Wait()
+	}
+	if l.closing {
+		return ErrClosed
+	}
+	if l.backgroundErr != nil {
+		return l.backgroundErr
+	}
+
+	l.manualCompaction = true
+	defer func() {
+		l.manualCompaction = false
+		l.background.Broadcast()
+	}()
+
+	if len(l.ramComponent) > 0 {
+		if err := l.freeze(); err != nil {
+			return err
+		}
+	}
+	for len(l.immutable) > 0 {
+		if err := l.flushImmutable(); err != nil {
+			l.backgroundErr = fmt.Errorf("%w: %w", ErrFlushingRAMComponent, err)
+			return l.backgroundErr
+		}
+	}
+
+	if len(l.sstables) == 1 {
+		l.sstables = append(l.sstables, make([]*sstable.SSTable, 0))
+	}
+	bottom := len(l.sstables) - 1
+	p := CompactionProgress{Steps: bottom + 1}
+	for level := 0; level <= bottom; level++ {
+		if l.closing {
+			return ErrClosed
+		}
+		if c := l.options.CompactionStrategy.PickRangeCompaction(l.sstables, level, start, end, l.options); c != nil {
+			compactedBytes := l.compactedBytes
+			if err := l.compact(c); err != nil {
+				l.backgroundErr = fmt.Errorf("%w: %w", ErrMergingSSTables, err)
+				return l.backgroundErr
+			}
+			p.BytesWritten += l.compactedBytes - compactedBytes
+		}
+
+		p.Level, p.Step = level, level+1
+		if progress != nil {
+			l.mu.Unlock()
+			progress(p)
+			l.mu.Lock()
+		}
+	}
+
+	// every table holding keys within the range was merged with the removed values masked out
+	for key := range l.removed {
+		if (start == nil || l.options.Comparator.Compare([]byte(key), start) >= 0) &&
+			(end == nil || l.options.Comparator.Compare([]byte(key), end) <= 0) {
+			delete(l.removed, key)
+		}
+	}
+	return l.logEdit(l.newVersionEdit())
+}
@@ -0,0 +1,100 @@
+package lsm_tree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactAll(t *testing.T) {
+	l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 10, LevelFanOut: 3})
+	require.NoError(t, err)
+	for round := range 4 {
+		for key := range 25 {
+			require.NoError(t, l.Add(testKey(key), uint16(round)))
+		}
+	}
+	require.NoError(t, l.Remove(testKey(1), 1))
+	require.NoError(t, l.Delete(3))
+	require.NoError(t, l.Flush())
+	levels := len(l.sstables)
+	require.Greater(t, levels, 2)
+
+	var steps []CompactionProgress
+	err = l.CompactAll(func(p CompactionProgress) {
+		steps = append(steps, p)
+		// searches are not blocked by the compaction
+		require.Equal(t, []uint16{0, 2}, values(t, l, testKey(1)))
+	})
+	require.NoError(t, err)
+	require.Len(t, steps, levels)
+	for j, p := range steps {
+		require.Equal(t, j, p.Level)
+		require.Equal(t, j+1, p.Step)
+		require.Equal(t, levels, p.Steps)
+	}
+	require.Positive(t, steps[levels-1].BytesWritten)
+
+	for level := range levels - 1 {
+		require.Empty(t, l.sstables[level])
+	}
+	require.Len(t, l.sstables[levels-1], 1)
+	require.Empty(t, l.removed)
+	require.Equal(t, []uint16{3}, l.Tombstones().ConvertToArray().Values)
+	require.Equal(t, []uint16{0, 2}, values(t, l, testKey(1)))
+	require.Equal(t, []uint16{0, 1, 2}, values(t, l, testKey(2)))
+
+	// a value added again after the compaction is not masked by the dropped removal
+	require.NoError(t, l.Add(testKey(1), 1))
+	require.Equal(t, []uint16{0, 1, 2}, values(t, l, testKey(1)))
+	require.NoError(t, l.Close())
+	require.ErrorIs(t, l.CompactAll(nil), ErrClosed)
+}
+
+func TestCompactRange(t *testing.T) {
+	dir := t.TempDir()
+	options := Options{
+		Dir:                dir,
+		MemTableKeys:       50,
+		LevelFanOut:        2,
+		CompactionStrategy: LeveledCompaction{BaseLevelSize: 2 << 10, TableSize: 512},
+	}
+	l, err := Open(options)
+	require.NoError(t, err)
+	for round := range 3 {
+		for j := range 500 {
+			require.NoError(t, l.Add(testKey(j*7919%500), uint16(round)))
+		}
+	}
+	require.NoError(t, l.Remove(testKey(100), 0))
+	require.NoError(t, l.Remove(testKey(400), 0))
+	require.NoError(t, l.Add(testKey(1000), 0))
+
+	err = l.CompactRange(testKey(50), testKey(150), func(p CompactionProgress) {
+		if p.Step < p.Steps {
+			return
+		}
+		// the background worker may merge the last level further once the compaction is done
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		require.Empty(t, l.ramComponent)
+		bottom := l.sstables[len(l.sstables)-1]
+		require.NotEmpty(t, overlapping(l.options.Comparator, bottom, testKey(50), testKey(150)))
+		for level := 0; level < len(l.sstables)-1; level++ {
+			require.Empty(t, overlapping(l.options.Comparator, l.sstables[level], testKey(50), testKey(150)))
+		}
+	})
+	require.NoError(t, err)
+	require.NotContains(t, l.removed, string(testKey(100)))
+	require.Contains(t, l.removed, string(testKey(400)))
+	require.NoError(t, l.Close())
+
+	l, err = Open(options)
+	require.NoError(t, err)
+	require.NotContains(t, l.removed, string(testKey(100)))
+	require.Equal(t, []uint16{1, 2}, values(t, l, testKey(100)))
+	require.Equal(t, []uint16{1, 2}, values(t, l, testKey(400)))
+	require.Equal(t, []uint16{0, 1, 2}, values(t, l, testKey(499)))
+	require.Equal(t, []uint16{0}, values(t, l, testKey(1000)))
+	require.NoError(t, l.Close())
+}
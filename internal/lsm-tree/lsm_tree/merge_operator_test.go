@@ -0,0 +1,55 @@
+package lsm_tree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"inverted-index/internal/lsm-tree/merge_operator"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+func TestMergeOperator(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		mergeOperator merge_operator.MergeOperator
+		expected      []uint16
+	}{
+		{"union", merge_operator.Union{}, []uint16{1, 2, 3, 4, 5}},
+		{"tombstone-aware union", merge_operator.TombstoneAwareUnion{}, []uint16{1, 3, 4, 5}},
+		{"last value wins", merge_operator.LastValueWins{}, []uint16{5}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// every change of the key goes to its own table, so that they are merged on
+			// search, on level merges and in the RAM component
+			l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 2, LevelFanOut: 2, MergeOperator: tc.mergeOperator})
+			require.NoError(t, err)
+			defer func() { require.NoError(t, l.Close()) }()
+			for j, values := range [][]uint16{{1, 2}, {3}, {4}} {
+				require.NoError(t, l.AddContainers(map[string]roaring_bitmap.Container{
+					string(testKey(0)):        roaring_bitmap.FromSortedValues(values),
+					string(testKey(1000 + j)): roaring_bitmap.FromSortedValues(values),
+				}))
+			}
+			waitIdle(t, l)
+			require.Len(t, l.sstables[0], 1)
+			require.Len(t, l.sstables[1], 1)
+
+			require.NoError(t, l.Remove(testKey(0), 2))
+			require.NoError(t, l.Add(testKey(0), 5))
+			require.Equal(t, tc.expected, values(t, l, testKey(0)))
+		})
+	}
+}
+
+func TestMergeOperator_LastValueWinsInRAM(t *testing.T) {
+	l, err := New(Options{Dir: t.TempDir(), MergeOperator: merge_operator.LastValueWins{}})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, l.Close()) }()
+	require.NoError(t, l.Add(testKey(0), 1))
+	require.NoError(t, l.Add(testKey(0), 2))
+	require.Equal(t, []uint16{2}, values(t, l, testKey(0)))
+
+	require.NoError(t, l.Delete(2))
+	require.Nil(t, values(t, l, testKey(0)))
+}
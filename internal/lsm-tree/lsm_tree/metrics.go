@@ -0,0 +1,71 @@
+package lsm_tree
+
+// Metrics describe the tables of a tree and the work done on them since it was created or opened.
+type Metrics struct {
+	// Levels holds the tables of every level, from level 0
+	Levels []LevelMetrics
+	// FlushedBytes is the size of the tables written from RAM components
+	FlushedBytes int
+	// CompactedBytes is the size of the tables written by merges
+	CompactedBytes int
+	Compactions    int
+	Searches       int64
+	// TablesProbed is the number of tables searches looked into, having the key within their key ranges
+	TablesProbed int64
+	// CacheHits and CacheMisses count the containers of probed tables found in the cache and not
+	CacheHits   int64
+	CacheMisses int64
+	// CacheSize is the memory taken by the cached containers in bytes
+	CacheSize int
+}
+
+type LevelMetrics struct {
+	Tables int
+	// Size is the size of the files of the tables in bytes
+	Size int
+}
+
+// WriteAmplification returns the bytes written to tables per byte flushed from the RAM components.
+func (m Metrics) WriteAmplification() float64 {
+	if m.FlushedBytes == 0 {
+		return 0
+	}
+	return float64(m.FlushedBytes+m.CompactedBytes) / float64(m.FlushedBytes)
+}
+
+// CacheHitRatio returns the share of probed tables whose containers were found in the cache.
+func (m Metrics) CacheHitRatio() float64 {
+	if m.CacheHits+m.CacheMisses == 0 {
+		return 0
+	}
+	return float64(m.CacheHits) / float64(m.CacheHits+m.CacheMisses)
+}
+
+// ReadAmplification returns the tables probed per search.
+func (m Metrics) ReadAmplification() float64 {
+	if m.Searches == 0 {
+		return 0
+	}
+	return float64(m.TablesProbed) / float64(m.Searches)
+}
+
+func (l *LSMTree) Metrics() Metrics {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	m := Metrics{
+		Levels:         make([]LevelMetrics, len(l.sstables)),
+		FlushedBytes:   l.flushedBytes,
+		CompactedBytes: l.compactedBytes,
+		Compactions:    l.compactions,
+		Searches:       l.searches.Load(),
+		TablesProbed:   l.tablesProbed.Load(),
+	}
+	if l.cache != nil {
+		m.CacheHits, m.CacheMisses, m.CacheSize = l.cache.hits.Load(), l.cache.misses.Load(), l.cache.size()
+	}
+	for level, tables := range l.sstables {
+		m.Levels[level] = LevelMetrics{Tables: len(tables), Size: levelSize(tables)}
+	}
+	return m
+}
@@ -0,0 +1,122 @@
+package lsm_tree
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"inverted-index/internal/lsm-tree/sstable"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// mappedContainers are posting lists kept as an array, a run and a bitmap container.
+func mappedContainers() map[string]roaring_bitmap.Container {
+	array := []uint16{1, 5, 9, 1000}
+	run := make([]uint16, 0, 3000)
+	for value := range uint16(3000) {
+		run = append(run, value+100)
+	}
+	bitmap := make([]uint16, 0, 6000)
+	for value := uint16(0); value < 12000; value += 2 {
+		bitmap = append(bitmap, value)
+	}
+	return map[string]roaring_bitmap.Container{
+		string(testKey(0)): roaring_bitmap.FromSortedValues(array),
+		string(testKey(1)): roaring_bitmap.FromSortedValues(run),
+		string(testKey(2)): roaring_bitmap.FromSortedValues(bitmap),
+	}
+}
+
+func requireMappedContainers(t *testing.T, l *LSMTree) {
+	for key, expected := range mappedContainers() {
+		c, err := l.Search([]byte(key))
+		require.NoError(t, err)
+		require.IsType(t, expected, c)
+		require.Equal(t, expected.ConvertToArray().Values, c.ConvertToArray().Values)
+	}
+}
+
+func TestMemoryMap(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("tables are only mapped on Linux")
+	}
+	for _, compression := range []sstable.Compression{sstable.NoCompression, sstable.FlateCompression} {
+		dir := t.TempDir()
+		options := Options{Dir: dir, MemoryMap: true, CacheSize: -1, Compression: []sstable.Compression{compression}}
+		l, err := Open(options)
+		require.NoError(t, err)
+		require.NoError(t, l.AddContainers(mappedContainers()))
+		require.NoError(t, l.Flush())
+		require.True(t, l.sstables[0][0].Mapped())
+		requireMappedContainers(t, l)
+
+		// the merged tables are read through the mapping too
+		require.NoError(t, l.CompactAll(nil))
+		requireMappedContainers(t, l)
+		require.NoError(t, l.Close())
+
+		l, err = Open(options)
+		require.NoError(t, err)
+		for _, tables := range l.sstables {
+			for _, table := range tables {
+				require.True(t, table.Mapped())
+			}
+		}
+		requireMappedContainers(t, l)
+		require.NoError(t, l.Close())
+	}
+}
+
+func TestMemoryMap_HeldContainers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("tables are only mapped on Linux")
+	}
+	l, err := New(Options{Dir: t.TempDir(), MemoryMap: true})
+	require.NoError(t, err)
+	require.NoError(t, l.AddContainers(mappedContainers()))
+	require.NoError(t, l.Flush())
+	table := l.sstables[0][0]
+	path := filepath.Join(l.dataDir, table.Name())
+	elements := make(map[string]*sstable.TableElement)
+	found := make(map[string]roaring_bitmap.Container)
+	for key := range mappedContainers() {
+		elements[key], err = table.SearchKey([]byte(key))
+		require.NoError(t, err)
+		found[key], err = l.Search([]byte(key))
+		require.NoError(t, err)
+	}
+
+	// the table is merged and removed and the tree is closed, but the table stays mapped
+	// while the containers read in place from it are held
+	require.NoError(t, l.AddContainers(mappedContainers()))
+	require.NoError(t, l.Flush())
+	require.NoError(t, l.CompactAll(nil))
+	require.NoError(t, l.Close())
+	require.True(t, table.Mapped())
+	require.FileExists(t, path)
+	for key, expected := range mappedContainers() {
+		require.Equal(t, expected.ConvertToArray().Values, elements[key].Value.ConvertToArray().Values)
+		require.NoError(t, elements[key].Release())
+	}
+	require.False(t, table.Mapped())
+	require.NoFileExists(t, path)
+
+	// the containers searches return are not views, so they outlive the table and can be modified
+	for key, expected := range mappedContainers() {
+		require.Equal(t, expected.ConvertToArray().Values, found[key].ConvertToArray().Values)
+		found[key].Add(20000)
+		require.True(t, found[key].Contains(20000))
+	}
+}
+
+func TestMemoryMap_Disabled(t *testing.T) {
+	l, err := New(Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, l.Close()) }()
+	require.NoError(t, l.AddContainers(mappedContainers()))
+	require.NoError(t, l.Flush())
+	require.False(t, l.sstables[0][0].Mapped())
+	requireMappedContainers(t, l)
+}
@@ -0,0 +1,141 @@
+package lsm_tree
+
+import (
+	"fmt"
+	"time"
+
+	"inverted-index/internal/lsm-tree/bloom_filter"
+	"inverted-index/internal/lsm-tree/common"
+	"inverted-index/internal/lsm-tree/merge_operator"
+	"inverted-index/internal/lsm-tree/sstable"
+)
+
+// defaultMemTableSize is the RAM component byte budget of DefaultOptions
+const defaultMemTableSize = 64 << 20
+
+// Options configure a tree. Zero fields take the values of DefaultOptions.
+type Options struct {
+	// Dir is where the tree keeps its files; trees in different directories are independent
+	Dir string
+	// MemTableKeys is the number of keys at which the RAM component is flushed
+	MemTableKeys int
+	// MemTableSize is the approximate size of the RAM component in bytes at which it is flushed
+	MemTableSize int
+	// LevelFanOut is the number of tables a level holds before they are merged with
+	// TieredCompaction, or level 0 and the size ratio of levels with LeveledCompaction
+	LevelFanOut int
+	// L0StopWritesTrigger is the number of level 0 tables, counting the RAM components
+	// waiting to be flushed, at which writers wait for merges; zero means twice LevelFanOut
+	L0StopWritesTrigger int
+	// BloomBitsPerKey is the size of the bloom filters of new sstables per key; a filter
+	// is stored with its parameters, so the size can change between opens
+	BloomBitsPerKey int
+	// Compression is the compression of the data blocks of the tables written to every
+	// level, the last one for the levels past its end; none if it is empty. Tables keep
+	// the compression they were written with, so it can change between opens.
+	Compression []sstable.Compression
+	// CompactionStrategy picks the tables to merge; a tree has to be reopened with the same strategy
+	CompactionStrategy CompactionStrategy
+	// CompactionFilter, if not nil, rewrites the values of the tables written by merges
+	CompactionFilter CompactionFilter
+	// Comparator orders keys; a tree has to be reopened with the same comparator
+	Comparator common.Comparator
+	// MergeOperator combines the values of a key written at different times
+	MergeOperator merge_operator.MergeOperator
+	// WALSync tells when the write-ahead log is flushed to stable storage
+	WALSync SyncPolicy
+	// WALSyncInterval is how often the log is synced with SyncPeriodically
+	WALSyncInterval time.Duration
+	// CacheSize is the budget in bytes of the cache of containers read from the tables,
+	// which all tables of the tree share; a negative size disables the cache
+	CacheSize int
+	// MemoryMap maps the table files into memory, so that searches read containers in
+	// place instead of copying them, and so without the cache; tables that fail to be
+	// mapped, e.g. on systems other than Linux, are read with file I/O
+	MemoryMap bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Dir:                 common.Dir,
+		MemTableKeys:        common.FirstLevelSize,
+		MemTableSize:        defaultMemTableSize,
+		LevelFanOut:         common.MaxLevelSize,
+		L0StopWritesTrigger: 2 * common.MaxLevelSize,
+		BloomBitsPerKey:     bloom_filter.DefaultBitsPerKey,
+		CompactionStrategy:  TieredCompaction{},
+		Comparator:          common.BytewiseComparator{},
+		MergeOperator:       merge_operator.TombstoneAwareUnion{},
+		WALSync:             SyncPeriodically,
+		WALSyncInterval:     100 * time.Millisecond,
+		CacheSize:           defaultCacheSize,
+	}
+}
+
+func (o Options) withDefaults() (Options, error) {
+	defaults := DefaultOptions()
+	if o.Dir == "" {
+		o.Dir = defaults.Dir
+	}
+	if o.MemTableKeys == 0 {
+		o.MemTableKeys = defaults.MemTableKeys
+	}
+	if o.MemTableSize == 0 {
+		o.MemTableSize = defaults.MemTableSize
+	}
+	if o.LevelFanOut == 0 {
+		o.LevelFanOut = defaults.LevelFanOut
+	}
+	if o.L0StopWritesTrigger == 0 {
+		o.L0StopWritesTrigger = 2 * o.LevelFanOut
+	}
+	if o.BloomBitsPerKey == 0 {
+		o.BloomBitsPerKey = defaults.BloomBitsPerKey
+	}
+	if o.CompactionStrategy == nil {
+		o.CompactionStrategy = defaults.CompactionStrategy
+	}
+	if o.Comparator == nil {
+		o.Comparator = defaults.Comparator
+	}
+	if o.MergeOperator == nil {
+		o.MergeOperator = defaults.MergeOperator
+	}
+	if o.WALSyncInterval == 0 {
+		o.WALSyncInterval = defaults.WALSyncInterval
+	}
+	if o.CacheSize == 0 {
+		o.CacheSize = defaults.CacheSize
+	}
+
+	switch {
+	case o.MemTableKeys < 0:
+		return o, fmt.Errorf("%w: negative memtable keys number", ErrInvalidOptions)
+	case o.MemTableSize < 0:
+		return o, fmt.Errorf("%w: negative memtable size", ErrInvalidOptions)
+	case o.LevelFanOut < 2:
+		return o, fmt.Errorf("%w: level fan-out less than 2", ErrInvalidOptions)
+	case o.L0StopWritesTrigger < o.LevelFanOut:
+		// level 0 would never fill up to be merged
+		return o, fmt.Errorf("%w: level 0 stop writes trigger less than level fan-out", ErrInvalidOptions)
+	case o.BloomBitsPerKey < 0:
+		return o, fmt.Errorf("%w: negative bloom filter bits per key", ErrInvalidOptions)
+	case o.WALSync < SyncPeriodically || o.WALSync > SyncNever:
+		return o, fmt.Errorf("%w: unknown write-ahead log sync policy", ErrInvalidOptions)
+	case o.WALSyncInterval < 0:
+		return o, fmt.Errorf("%w: negative write-ahead log sync interval", ErrInvalidOptions)
+	}
+	for _, compression := range o.Compression {
+		if compression != sstable.NoCompression && compression != sstable.FlateCompression {
+			return o, fmt.Errorf("%w: unknown %v", ErrInvalidOptions, compression)
+		}
+	}
+	return o, nil
+}
+
+func (o Options) compression(level int) sstable.Compression {
+	if len(o.Compression) == 0 {
+		return sstable.NoCompression
+	}
+	return o.Compression[min(level, len(o.Compression)-1)]
+}
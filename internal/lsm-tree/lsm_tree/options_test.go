@@ -0,0 +1,128 @@
+package lsm_tree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"inverted-index/internal/lsm-tree/sstable"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+func TestOptions_Defaults(t *testing.T) {
+	options, err := Options{Dir: "dir", LevelFanOut: 3}.withDefaults()
+	require.NoError(t, err)
+
+	expected := DefaultOptions()
+	expected.Dir = "dir"
+	expected.LevelFanOut = 3
+	expected.L0StopWritesTrigger = 6
+	require.Equal(t, expected, options)
+
+	_, err = New(Options{LevelFanOut: 1})
+	require.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = New(Options{MemTableSize: -1})
+	require.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = New(Options{LevelFanOut: 4, L0StopWritesTrigger: 3})
+	require.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = New(Options{Compression: []sstable.Compression{sstable.FlateCompression + 1}})
+	require.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestOptions_MemTable(t *testing.T) {
+	l, err := New(Options{Dir: t.TempDir(), MemTableKeys: 10, LevelFanOut: 2})
+	require.NoError(t, err)
+	for key := range 9 {
+		require.NoError(t, l.Add(testKey(key), 1))
+	}
+	require.Empty(t, l.sstables[0])
+	require.NoError(t, l.Add(testKey(9), 1))
+	waitIdle(t, l)
+	require.Len(t, l.sstables[0], 1)
+	for key := range 10 {
+		require.NoError(t, l.Add(testKey(key), 2))
+	}
+	waitIdle(t, l)
+	require.Empty(t, l.sstables[0])
+	require.Len(t, l.sstables[1], 1)
+	require.NoError(t, l.Close())
+
+	// a few large containers fill the byte budget long before the keys limit
+	l, err = New(Options{Dir: t.TempDir(), MemTableSize: 1 << 10})
+	require.NoError(t, err)
+	for value := range 256 {
+		require.NoError(t, l.Add(testKey(0), uint16(value)))
+	}
+	waitIdle(t, l)
+	require.Empty(t, l.sstables[0])
+	for value := range 256 {
+		require.NoError(t, l.Add(testKey(1), uint16(value)))
+	}
+	waitIdle(t, l)
+	require.Len(t, l.sstables[0], 1)
+	require.NoError(t, l.Close())
+}
+
+func TestOptions_SeparateDirs(t *testing.T) {
+	options := Options{MemTableKeys: 1}
+	options.Dir = t.TempDir()
+	l1, err := New(options)
+	require.NoError(t, err)
+	options.Dir = t.TempDir()
+	l2, err := New(options)
+	require.NoError(t, err)
+
+	require.NoError(t, l1.Add(testKey(0), 1))
+	require.NoError(t, l2.Add(testKey(0), 2))
+	require.Equal(t, []uint16{1}, values(t, l1, testKey(0)))
+	require.Equal(t, []uint16{2}, values(t, l2, testKey(0)))
+	require.NoError(t, l1.Close())
+	require.NoError(t, l2.Close())
+}
+
+func TestOptions_Compression(t *testing.T) {
+	fill := func(options Options) *LSMTree {
+		l, err := Open(options)
+		require.NoError(t, err)
+		// the same posting lists over and over, as for the date keys of an index
+		values := make([]uint16, 0, 500)
+		for value := uint16(0); value < 1000; value += 2 {
+			values = append(values, value)
+		}
+		for key := range 400 {
+			require.NoError(t, l.AddContainers(map[string]roaring_bitmap.Container{
+				string(testKey(key)): roaring_bitmap.FromSortedValues(values),
+			}))
+		}
+		require.NoError(t, l.Flush())
+		return l
+	}
+	size := func(l *LSMTree) int {
+		size := 0
+		for _, level := range l.Metrics().Levels {
+			size += level.Size
+		}
+		return size
+	}
+
+	options := Options{Dir: t.TempDir(), MemTableKeys: 100, LevelFanOut: 2}
+	plain := fill(options)
+	options.Dir = t.TempDir()
+	options.Compression = []sstable.Compression{sstable.NoCompression, sstable.FlateCompression}
+	compressed := fill(options)
+	require.Less(t, size(compressed), size(plain)/4)
+	require.Equal(t, 500, len(values(t, compressed, testKey(123))))
+	require.NoError(t, plain.Close())
+	require.NoError(t, compressed.Close())
+
+	// tables written with different compressions are merged together
+	options.Compression = nil
+	l, err := Open(options)
+	require.NoError(t, err)
+	require.NoError(t, l.Add(testKey(123), 1))
+	require.NoError(t, l.Add(testKey(1000), 1))
+	require.NoError(t, l.CompactAll(nil))
+	require.Equal(t, 501, len(values(t, l, testKey(123))))
+	require.Equal(t, []uint16{1}, values(t, l, testKey(1000)))
+	require.NoError(t, l.Close())
+}
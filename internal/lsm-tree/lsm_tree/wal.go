@@ -0,0 +1,247 @@
+package lsm_tree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"inverted-index/internal/lsm-tree/common"
+)
+
+// SyncPolicy tells when the write-ahead log is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncPeriodically syncs the log in the background, so a crash loses at most
+	// the changes of the last sync interval.
+	SyncPeriodically SyncPolicy = iota
+	// SyncAlways syncs the log on every change, so nothing is lost on a crash.
+	SyncAlways
+	// SyncNever leaves syncing to the operating system; the log still survives a
+	// crash of the process, but not of the machine.
+	SyncNever
+)
+
+type walOperation uint8
+
+const (
+	walAdd walOperation = iota
+	walAddValues
+	walRemove
+	walDelete
+)
+
+// walRecord carries one value for Add and Remove, a sorted container for AddValues and no key for Delete.
+type walRecord struct {
+	operation walOperation
+	key       []byte
+	values    []uint16
+}
+
+const (
+	// walHeaderSize is the size of the checksum and the length preceding every record payload
+	walHeaderSize = 8
+	// walMaxPayloadSize is the size of a record payload with the longest key and the largest container
+	walMaxPayloadSize = 3 + common.MaxKeySize + 2*(1<<16)
+)
+
+var walChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// wal is the write-ahead log of the RAM component. Every change is appended to it
+// before it is applied, and the log is truncated once the RAM component is flushed.
+type wal struct {
+	// mu guards file against the background sync
+	mu     sync.Mutex
+	file   *os.File
+	policy SyncPolicy
+	// dirty tells that something was written since the last sync
+	dirty bool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// openWAL cuts off a torn or corrupted tail left by a crash.
+func openWAL(path string, policy SyncPolicy, syncInterval time.Duration) (*wal, []walRecord, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0660)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrOpeningWAL, err)
+	}
+
+	records, validSize, err := readWALRecords(bufio.NewReader(file))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = file.Truncate(validSize); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrOpeningWAL, err)
+	}
+
+	w := &wal{
+		file:   file,
+		policy: policy,
+	}
+	if policy == SyncPeriodically {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncPeriodically(syncInterval)
+	}
+
+	return w, records, nil
+}
+
+func readWALRecords(reader io.Reader) (records []walRecord, validSize int64, err error) {
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err = io.ReadFull(reader, header); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return records, validSize, nil
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("%w: %w", ErrReadingWAL, err)
+		}
+		checksum := binary.LittleEndian.Uint32(header[:4])
+		length := binary.LittleEndian.Uint32(header[4:])
+		// a valid payload holds the operation, the key length and at least one value
+		if length < 5 || length > walMaxPayloadSize {
+			return records, validSize, nil
+		}
+
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(reader, payload); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return records, validSize, nil
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("%w: %w", ErrReadingWAL, err)
+		}
+		if crc32.Checksum(payload, walChecksumTable) != checksum {
+			return records, validSize, nil
+		}
+
+		r, ok := walRecordFromBytes(payload)
+		if !ok {
+			return records, validSize, nil
+		}
+		records = append(records, r)
+		validSize += walHeaderSize + int64(length)
+	}
+}
+
+func (r *walRecord) toBytes() []byte {
+	valuesOffset := 3 + len(r.key)
+	payloadSize := valuesOffset + 2*len(r.values)
+	b := make([]byte, walHeaderSize+payloadSize)
+
+	payload := b[walHeaderSize:]
+	payload[0] = byte(r.operation)
+	binary.LittleEndian.PutUint16(payload[1:], uint16(len(r.key)))
+	copy(payload[3:], r.key)
+	for j, value := range r.values {
+		binary.LittleEndian.PutUint16(payload[valuesOffset+2*j:], value)
+	}
+
+	binary.LittleEndian.PutUint32(b, crc32.Checksum(payload, walChecksumTable))
+	binary.LittleEndian.PutUint32(b[4:], uint32(payloadSize))
+	return b
+}
+
+func walRecordFromBytes(payload []byte) (walRecord, bool) {
+	valuesOffset := 3 + int(binary.LittleEndian.Uint16(payload[1:]))
+	valuesSize := len(payload) - valuesOffset
+	if valuesSize < 2 || valuesSize%2 != 0 {
+		return walRecord{}, false
+	}
+
+	r := walRecord{
+		operation: walOperation(payload[0]),
+		key:       payload[3:valuesOffset],
+		values:    make([]uint16, valuesSize/2),
+	}
+	for j := range r.values {
+		r.values[j] = binary.LittleEndian.Uint16(payload[valuesOffset+2*j:])
+	}
+	return r, true
+}
+
+// append writes records with a single call, so that a crash can only tear the last one.
+func (w *wal) append(records ...walRecord) error {
+	var b []byte
+	for _, r := range records {
+		b = append(b, r.toBytes()...)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Write(b); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingWAL, err)
+	}
+	w.dirty = true
+	if w.policy == SyncAlways {
+		return w.sync()
+	}
+	return nil
+}
+
+func (w *wal) sync() error {
+	if !w.dirty {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("%w: %w", ErrSyncingWAL, err)
+	}
+	w.dirty = false
+	return nil
+}
+
+func (w *wal) flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.sync()
+}
+
+func (w *wal) syncPeriodically(interval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			// a failed sync is retried on the next tick and reported by close
+			_ = w.sync()
+			w.mu.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *wal) close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// remove closes the log and deletes its file once its records are durable elsewhere.
+func (w *wal) remove() error {
+	if err := w.close(); err != nil {
+		return err
+	}
+	if err := os.Remove(w.file.Name()); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingWAL, err)
+	}
+	return nil
+}
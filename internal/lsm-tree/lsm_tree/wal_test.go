@@ -0,0 +1,116 @@
+package lsm_tree
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"inverted-index/internal/lsm-tree/common"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// testKey encodes n as a key ordered like the numbers.
+func testKey(n int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(n))
+}
+
+func values(t *testing.T, l *LSMTree, key []byte) []uint16 {
+	c, err := l.Search(key)
+	require.NoError(t, err)
+	if c == nil {
+		return nil
+	}
+	return c.ConvertToArray().Values
+}
+
+func TestWAL_Replay(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncPeriodically, SyncNever} {
+		dir := t.TempDir()
+		options := Options{Dir: dir, WALSync: policy, WALSyncInterval: time.Millisecond}
+
+		l, err := Open(options)
+		require.NoError(t, err)
+		require.NoError(t, l.Add(testKey(1), 10))
+		require.NoError(t, l.Add(testKey(1), 11))
+		require.NoError(t, l.Add(testKey(2), 10))
+		require.NoError(t, l.AddContainers(map[string]roaring_bitmap.Container{
+			string(testKey(3)): roaring_bitmap.FromSortedValues([]uint16{1, 2, 3}),
+		}))
+		require.NoError(t, l.Remove(testKey(1), 11))
+		require.NoError(t, l.Delete(2))
+		// the tree is abandoned without Close, as on a crash
+
+		l, err = Open(options)
+		require.NoError(t, err)
+		require.Equal(t, []uint16{10}, values(t, l, testKey(1)))
+		require.Equal(t, []uint16{10}, values(t, l, testKey(2)))
+		require.Equal(t, []uint16{1, 3}, values(t, l, testKey(3)))
+		require.NoError(t, l.Close())
+	}
+}
+
+func TestWAL_TornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(Options{Dir: dir})
+	require.NoError(t, err)
+	require.NoError(t, l.Add(testKey(1), 10))
+	require.NoError(t, l.Add(testKey(1), 11))
+	walPath := l.walPath(l.walNumber)
+	require.NoError(t, l.Close())
+
+	info, err := os.Stat(walPath)
+	require.NoError(t, err)
+	// the last record is both torn and corrupted
+	require.NoError(t, os.Truncate(walPath, info.Size()-1))
+	file, err := os.OpenFile(walPath, os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = file.WriteAt([]byte{0xff, 0xff}, info.Size()/2+4)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	l, err = Open(Options{Dir: dir})
+	require.NoError(t, err)
+	require.Equal(t, []uint16{10}, values(t, l, testKey(1)))
+	require.NoError(t, l.Add(testKey(1), 12))
+	require.NoError(t, l.Close())
+
+	l, err = Open(Options{Dir: dir})
+	require.NoError(t, err)
+	require.Equal(t, []uint16{10, 12}, values(t, l, testKey(1)))
+	require.NoError(t, l.Close())
+}
+
+func TestWAL_RotatedOnFlush(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(Options{Dir: dir, WALSync: SyncNever})
+	require.NoError(t, err)
+	for key := range common.FirstLevelSize - 1 {
+		require.NoError(t, l.Add(testKey(key), 1))
+	}
+	require.NoError(t, l.Delete(1))
+	oldWALPath := l.walPath(l.walNumber)
+	info, err := os.Stat(oldWALPath)
+	require.NoError(t, err)
+	require.NotZero(t, info.Size())
+
+	require.NoError(t, l.Add(testKey(common.FirstLevelSize), 2))
+	waitIdle(t, l)
+	require.NoFileExists(t, oldWALPath)
+	info, err = os.Stat(l.walPath(l.walNumber))
+	require.NoError(t, err)
+	require.Zero(t, info.Size())
+	require.NoError(t, l.Add(testKey(common.FirstLevelSize+1), 3))
+	require.NoError(t, l.Close())
+
+	l, err = Open(Options{Dir: dir})
+	require.NoError(t, err)
+	require.Nil(t, values(t, l, testKey(0)))
+	require.Equal(t, []uint16{2}, values(t, l, testKey(common.FirstLevelSize)))
+	require.Equal(t, []uint16{3}, values(t, l, testKey(common.FirstLevelSize+1)))
+	require.NoError(t, l.Close())
+}
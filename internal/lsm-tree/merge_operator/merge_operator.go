@@ -0,0 +1,88 @@
+package merge_operator
+
+import (
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// Operand is a single change of the value of a key.
+type Operand struct {
+	Value roaring_bitmap.Container
+	// Tombstone tells that the values of Value are removed from the key instead of being merged into it
+	Tombstone bool
+}
+
+// MergeOperator combines the changes of a key written at different times into its value.
+type MergeOperator interface {
+	// FullMerge returns the value of key given all of its operands, ordered from the oldest to the newest.
+	FullMerge(key []byte, operands []Operand) roaring_bitmap.Container
+	// PartialMerge combines two adjacent operands of key into one when the other operands are
+	// not at hand, e.g. in the RAM component or when sstables are merged. The older operand
+	// is never a tombstone, since tombstones are not kept in sstables.
+	PartialMerge(key []byte, older Operand, newer Operand) Operand
+}
+
+// Union merges values as sets and ignores tombstones, for values that are never removed.
+type Union struct{}
+
+func (Union) FullMerge(_ []byte, operands []Operand) roaring_bitmap.Container {
+	var result roaring_bitmap.Container
+	for _, operand := range operands {
+		if !operand.Tombstone {
+			result = roaring_bitmap.Or(result, operand.Value)
+		}
+	}
+	return result
+}
+
+func (Union) PartialMerge(_ []byte, older Operand, newer Operand) Operand {
+	if newer.Tombstone {
+		return older
+	}
+	return Operand{Value: roaring_bitmap.Or(older.Value, newer.Value)}
+}
+
+// TombstoneAwareUnion merges values as sets, removing the values of a tombstone
+// from everything older than it. This is how posting lists are merged.
+type TombstoneAwareUnion struct{}
+
+func (TombstoneAwareUnion) FullMerge(_ []byte, operands []Operand) roaring_bitmap.Container {
+	var result roaring_bitmap.Container
+	for _, operand := range operands {
+		if operand.Tombstone {
+			result = roaring_bitmap.AndNot(result, operand.Value)
+		} else {
+			result = roaring_bitmap.Or(result, operand.Value)
+		}
+	}
+	return result
+}
+
+func (TombstoneAwareUnion) PartialMerge(_ []byte, older Operand, newer Operand) Operand {
+	if newer.Tombstone {
+		return Operand{Value: roaring_bitmap.AndNot(older.Value, newer.Value)}
+	}
+	return Operand{Value: roaring_bitmap.Or(older.Value, newer.Value)}
+}
+
+// LastValueWins replaces the value with every new one, for values that are not sets,
+// e.g. serialized statistics. Tombstones still remove values from the latest one.
+type LastValueWins struct{}
+
+func (LastValueWins) FullMerge(_ []byte, operands []Operand) roaring_bitmap.Container {
+	var result roaring_bitmap.Container
+	for _, operand := range operands {
+		if operand.Tombstone {
+			result = roaring_bitmap.AndNot(result, operand.Value)
+		} else {
+			result = operand.Value
+		}
+	}
+	return result
+}
+
+func (LastValueWins) PartialMerge(_ []byte, older Operand, newer Operand) Operand {
+	if newer.Tombstone {
+		return Operand{Value: roaring_bitmap.AndNot(older.Value, newer.Value)}
+	}
+	return newer
+}
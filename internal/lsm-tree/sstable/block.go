@@ -0,0 +1,213 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// A table is a single file of data blocks holding the elements in the order of their
+// keys, followed by a filter block with the bloom filter of the keys, an index block
+// with the first key of every data block, and a fixed-size footer locating the two:
+//
+//	data block 0 | ... | data block n-1 | filter block | index block | footer
+//
+// The index is kept in memory, so a lookup reads the one data block that may hold the key.
+// Every block starts with its Compression and is followed by the CRC-32C of both, and
+// the footer holds the checksum of its own fields, so that a damaged file is reported
+// rather than decoded. Data blocks are preceded by up to 7 bytes of padding, so that their
+// contents start at a multiple of 8 bytes and the containers in them can be read in place
+// from a mapped file.
+
+const (
+	// blockSize is the size in bytes at which a data block is finished, so a block
+	// holds at least one element and may be larger by the last of them
+	blockSize = 4 << 10
+
+	// tableMagic ends every table file
+	tableMagic    uint64 = 0x7373_7461_626c_6531
+	formatVersion uint32 = 4
+
+	// blockAlignment is what the contents of data blocks are aligned to in the file
+	blockAlignment = 8
+
+	// blockTrailerSize is the size of the checksum following every block
+	blockTrailerSize = 4
+	blockHandleSize  = 8 + 4
+	footerSize       = 2*blockHandleSize + 4 + 4 + 8
+)
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+func checkBlock(b []byte, offset uint64) ([]byte, error) {
+	contents := b[:len(b)-blockTrailerSize]
+	if crc32.Checksum(contents, checksumTable) != binary.LittleEndian.Uint32(b[len(contents):]) {
+		return nil, fmt.Errorf("%w: checksum mismatch in the block at %d", ErrCorruption, offset)
+	}
+	return contents, nil
+}
+
+// blockHandle.size counts the compression but not the trailing checksum.
+type blockHandle struct {
+	offset uint64
+	size   uint32
+}
+
+func (h blockHandle) appendTo(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint64(b, h.offset)
+	return binary.LittleEndian.AppendUint32(b, h.size)
+}
+
+func blockHandleFromBytes(d *decoder) blockHandle {
+	return blockHandle{offset: d.uint64(), size: d.uint32()}
+}
+
+type indexEntry struct {
+	firstKey []byte
+	handle   blockHandle
+}
+
+type indexBlock struct {
+	elements int
+	entries  []indexEntry
+	largest  []byte
+}
+
+func (i *indexBlock) toBytes() []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(i.elements))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(i.entries)))
+	for _, entry := range i.entries {
+		b = appendKey(b, entry.firstKey)
+		b = entry.handle.appendTo(b)
+	}
+	return appendKey(b, i.largest)
+}
+
+func indexBlockFromBytes(b []byte) (*indexBlock, error) {
+	d := &decoder{b: b}
+	i := &indexBlock{elements: int(d.uint32())}
+	entries := int(d.uint32())
+	// every entry takes at least its key length and its handle
+	if entries > len(d.b)/(2+blockHandleSize) {
+		return nil, fmt.Errorf("%w: %d index entries in %d bytes", ErrCorruption, entries, len(b))
+	}
+	i.entries = make([]indexEntry, entries)
+	for j := range i.entries {
+		i.entries[j].firstKey = keyFromBytes(d)
+		i.entries[j].handle = blockHandleFromBytes(d)
+	}
+	i.largest = keyFromBytes(d)
+	if d.err != nil {
+		return nil, fmt.Errorf("%w: index block: %w", ErrCorruption, d.err)
+	}
+	if len(i.entries) == 0 {
+		i.largest = nil
+	}
+	return i, nil
+}
+
+type footer struct {
+	filter blockHandle
+	index  blockHandle
+}
+
+func (f footer) toBytes() []byte {
+	b := f.filter.appendTo(nil)
+	b = f.index.appendTo(b)
+	b = binary.LittleEndian.AppendUint32(b, formatVersion)
+	b = binary.LittleEndian.AppendUint32(b, crc32.Checksum(b, checksumTable))
+	return binary.LittleEndian.AppendUint64(b, tableMagic)
+}
+
+func footerFromBytes(b []byte) (footer, error) {
+	d := &decoder{b: b}
+	f := footer{filter: blockHandleFromBytes(d), index: blockHandleFromBytes(d)}
+	version, checksum, magic := d.uint32(), d.uint32(), d.uint64()
+	if d.err != nil {
+		return f, fmt.Errorf("%w: footer: %w", ErrReadingFromFile, d.err)
+	}
+	if magic != tableMagic {
+		return f, fmt.Errorf("%w: bad magic number %#x", ErrUnknownFormat, magic)
+	}
+	if crc32.Checksum(b[:2*blockHandleSize+4], checksumTable) != checksum {
+		return f, fmt.Errorf("%w: checksum mismatch in the footer", ErrCorruption)
+	}
+	if version != formatVersion {
+		return f, fmt.Errorf("%w: version %d", ErrUnknownFormat, version)
+	}
+	return f, nil
+}
+
+func appendKey(b []byte, key []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(key)))
+	return append(b, key...)
+}
+
+func keyFromBytes(d *decoder) []byte {
+	return d.next(int(d.uint16()))
+}
+
+// decoder returns zero values once a read goes past the end of b, and reports it by err.
+type decoder struct {
+	b []byte
+	// read is the number of bytes read so far, which padding is relative to
+	read int
+	// mapped tells if the block is read in place from a mapped file
+	mapped bool
+	err    error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.b) {
+		d.err, d.b = io.ErrUnexpectedEOF, nil
+		return nil
+	}
+	b := d.b[:n:n]
+	d.b = d.b[n:]
+	d.read += n
+	return b
+}
+
+func (d *decoder) align(alignment int) {
+	d.next(padding(d.read, alignment))
+}
+
+func padding(offset int, alignment int) int {
+	return -offset & (alignment - 1)
+}
+
+func (d *decoder) uint8() uint8 {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) done() bool {
+	return len(d.b) == 0
+}
@@ -0,0 +1,89 @@
+package sstable
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compression is how the data blocks of a table are compressed. Every block starts
+// with the compression it was written with, so blocks and tables written with
+// different ones can be mixed.
+type Compression uint8
+
+const (
+	NoCompression Compression = iota
+	// FlateCompression compresses blocks with DEFLATE; a block it does not make smaller is kept as is
+	FlateCompression
+)
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case FlateCompression:
+		return "flate"
+	default:
+		return fmt.Sprintf("compression(%d)", uint8(c))
+	}
+}
+
+type compressor struct {
+	buf   bytes.Buffer
+	flate *flate.Writer
+}
+
+// compress returns a buffer that is valid until the next call.
+func (c *compressor) compress(block []byte, compression Compression) ([]byte, error) {
+	c.buf.Reset()
+	if compression == FlateCompression {
+		c.buf.WriteByte(byte(FlateCompression))
+		if c.flate == nil {
+			c.flate, _ = flate.NewWriter(&c.buf, flate.DefaultCompression)
+		} else {
+			c.flate.Reset(&c.buf)
+		}
+		if _, err := c.flate.Write(block); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+		}
+		if err := c.flate.Close(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+		}
+		if c.buf.Len() < 1+len(block) {
+			return c.buf.Bytes(), nil
+		}
+		c.buf.Reset()
+	}
+	c.buf.WriteByte(byte(NoCompression))
+	c.buf.Write(block)
+	return c.buf.Bytes(), nil
+}
+
+var flateReaders sync.Pool
+
+func decompressBlock(b []byte, offset uint64) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w: the block at %d has no compression", ErrCorruption, offset)
+	}
+	switch compression := Compression(b[0]); compression {
+	case NoCompression:
+		return b[1:], nil
+	case FlateCompression:
+		r, ok := flateReaders.Get().(io.ReadCloser)
+		if ok {
+			_ = r.(flate.Resetter).Reset(bytes.NewReader(b[1:]), nil)
+		} else {
+			r = flate.NewReader(bytes.NewReader(b[1:]))
+		}
+		defer flateReaders.Put(r)
+		contents, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: the block at %d: %w", ErrCorruption, offset, err)
+		}
+		return contents, nil
+	default:
+		return nil, fmt.Errorf("%w: the block at %d has %v", ErrUnknownFormat, offset, compression)
+	}
+}
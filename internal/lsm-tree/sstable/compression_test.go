@@ -0,0 +1,88 @@
+package sstable
+
+import (
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"inverted-index/internal/lsm-tree/bloom_filter"
+	"inverted-index/internal/lsm-tree/common"
+	"inverted-index/internal/lsm-tree/merge_operator"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// mixedContainers returns containers of the even keys less than n: random bitmaps, which
+// fill a block each that flate does not make smaller, for the first half of them, and
+// sequences of even values, which compress well, for the rest.
+func mixedContainers(n int) map[string]roaring_bitmap.Container {
+	r := rand.New(rand.NewPCG(1, 2))
+	containers := make(map[string]roaring_bitmap.Container)
+	for key := 0; key < n; key += 2 {
+		var values []uint16
+		if key < n/2 {
+			for value := range 1<<16 - 1 {
+				if r.IntN(2) == 0 {
+					values = append(values, uint16(value))
+				}
+			}
+		} else {
+			for value := 0; value < 1000; value += 2 {
+				values = append(values, uint16(key+value))
+			}
+		}
+		containers[string(testKey(key))] = roaring_bitmap.FromSortedValues(values)
+	}
+	return containers
+}
+
+func TestCompression_MixedBlocks(t *testing.T) {
+	containers := mixedContainers(100)
+	table, path := newTestTable(t, containers, FlateCompression)
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	blocks := make(map[Compression]int)
+	for _, entry := range table.index {
+		blocks[Compression(b[entry.handle.offset])]++
+	}
+	require.Positive(t, blocks[NoCompression])
+	require.Positive(t, blocks[FlateCompression])
+	require.Len(t, blocks, 2)
+
+	for key, container := range containers {
+		require.Equal(t, container.ConvertToArray().Values, searchValues(t, table, []byte(key)))
+	}
+	it := table.NewIterator()
+	for key := 0; key < 100; key += 2 {
+		require.True(t, it.Next())
+		require.Equal(t, testKey(key), it.Element().Key)
+	}
+	require.False(t, it.Next())
+	require.NoError(t, it.Err())
+	corruptions, err := VerifyTable(path)
+	require.NoError(t, err)
+	require.Empty(t, corruptions)
+
+	// a compressed table is merged with an uncompressed one into a compressed table
+	plain, _ := newTestTable(t, evenKeys(200), NoCompression)
+	dir := t.TempDir()
+	merged, err := Merge(func() string { return filepath.Join(dir, "merged") }, []*SSTable{table, plain}, 0,
+		common.BytewiseComparator{}, merge_operator.TombstoneAwareUnion{},
+		func([]byte) roaring_bitmap.Container { return nil }, nil,
+		bloom_filter.DefaultBitsPerKey, FlateCompression)
+	require.NoError(t, err)
+	require.Len(t, merged, 1)
+	for key := 0; key < 200; key += 2 {
+		expected := []uint16{uint16(key), uint16(key) + 1}
+		if container, ok := containers[string(testKey(key))]; ok {
+			expected = roaring_bitmap.Or(container, roaring_bitmap.FromSortedValues(expected)).ConvertToArray().Values
+		}
+		require.Equal(t, expected, searchValues(t, merged[0], testKey(key)), key)
+	}
+	require.NoError(t, table.Close())
+	require.NoError(t, plain.Close())
+	require.NoError(t, merged[0].Close())
+}
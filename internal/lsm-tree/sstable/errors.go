@@ -5,10 +5,14 @@ import "errors"
 var (
 	ErrFileClosing     = errors.New("error closing file")
 	ErrFileCreating    = errors.New("failed to create file")
+	ErrFileOpening     = errors.New("failed to open file")
 	ErrFileSeeking     = errors.New("file seeking failed")
 	ErrReadingFromFile = errors.New("failed to read from file")
 	ErrSetFileOffset   = errors.New("failed to set file offset")
 	ErrWritingBytes    = errors.New("failed writing bytes value")
+	ErrUnknownFormat   = errors.New("not an sstable of a known format")
+	ErrCorruption      = errors.New("sstable corrupted")
+	ErrMapping         = errors.New("failed to map file into memory")
 
 	ErrBloomFilter    = errors.New("bloom filter error")
 	ErrMergingTables  = errors.New("error merging sstables")
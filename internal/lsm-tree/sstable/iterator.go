@@ -0,0 +1,76 @@
+package sstable
+
+// Iterator goes over the elements of a table in the order of their keys, reading
+// one data block at a time. It reads the file without moving its offset, so iterators
+// and searches can run concurrently, but the table must be referenced while it is used.
+type Iterator struct {
+	table *SSTable
+	// block is the index of the data block the rest of data is from
+	block   int
+	data    decoder
+	element *TableElement
+	err     error
+}
+
+// NewIterator returns an iterator positioned before the first element.
+func (s *SSTable) NewIterator() *Iterator {
+	return &Iterator{table: s, block: -1}
+}
+
+// Seek positions the iterator so that Next moves to the first element with a key
+// not less than key. A nil key moves to the first element.
+func (it *Iterator) Seek(key []byte) {
+	it.element = nil
+	if it.err != nil {
+		return
+	}
+	if key == nil {
+		it.block, it.data = -1, decoder{}
+		return
+	}
+	// the elements of the block before the one that may hold key are all less than it
+	if it.err = it.loadBlock(max(it.table.blockFor(key), 0)); it.err == nil {
+		it.err = it.table.seek(&it.data, key)
+	}
+}
+
+// Next moves to the next element, returning false when there is none or reading failed.
+func (it *Iterator) Next() bool {
+	it.element = nil
+	if it.err != nil {
+		return false
+	}
+	for it.data.done() {
+		if it.block+1 >= len(it.table.index) {
+			return false
+		}
+		if it.err = it.loadBlock(it.block + 1); it.err != nil {
+			return false
+		}
+	}
+	it.element, it.err = elementFromBlock(&it.data, true)
+	return it.err == nil
+}
+
+func (it *Iterator) loadBlock(block int) error {
+	it.block, it.data = block, decoder{}
+	if block >= len(it.table.index) {
+		return nil
+	}
+	b, err := it.table.readBlock(it.table.index[block].handle)
+	if err != nil {
+		return err
+	}
+	it.data.b = b
+	return nil
+}
+
+// Element returns the element Next moved to.
+func (it *Iterator) Element() *TableElement {
+	return it.element
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
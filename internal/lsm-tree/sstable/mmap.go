@@ -0,0 +1,64 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+var nativeLittleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// Map maps the file of the table into memory read-only, so that it is read without system
+// calls and SearchKey returns containers that are views of their values in the mapped file
+// rather than copies. Such containers must not be modified. The file stays mapped until the
+// table is closed and every element read from it is released, see TableElement.Release.
+// Mapping is only supported on Linux; if it fails, the table keeps reading the file with file I/O.
+func (s *SSTable) Map() error {
+	mapped, err := mmap(s.file, s.fileSize)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMapping, err)
+	}
+	s.mapped = mapped
+	return nil
+}
+
+// Mapped tells if the file of the table is mapped into memory.
+func (s *SSTable) Mapped() bool {
+	return s.mapped != nil
+}
+
+// viewUint16s, viewRunRecords and viewUint64s return nil if the values have to be copied.
+func viewUint16s(d *decoder, b []byte) []uint16 {
+	if p := viewable(d, b, 2); p != nil {
+		return unsafe.Slice((*uint16)(p), len(b)/2)
+	}
+	return nil
+}
+
+func viewRunRecords(d *decoder, b []byte) []roaring_bitmap.RunRecord {
+	// a record is laid out like its encoding, the start followed by the length
+	if p := viewable(d, b, 2); p != nil {
+		return unsafe.Slice((*roaring_bitmap.RunRecord)(p), len(b)/4)
+	}
+	return nil
+}
+
+func viewUint64s(d *decoder, b []byte) []uint64 {
+	if p := viewable(d, b, 8); p != nil {
+		return unsafe.Slice((*uint64)(p), len(b)/8)
+	}
+	return nil
+}
+
+func viewable(d *decoder, b []byte, alignment uintptr) unsafe.Pointer {
+	if !d.mapped || !nativeLittleEndian || len(b) == 0 {
+		return nil
+	}
+	p := unsafe.Pointer(unsafe.SliceData(b))
+	if uintptr(p)%alignment != 0 {
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,93 @@
+package sstable
+
+import (
+	"runtime"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+// containerKinds returns an array, a run and a bitmap container, in one block together.
+func containerKinds() map[string]roaring_bitmap.Container {
+	array := []uint16{1, 5, 9, 1000}
+	run := make([]uint16, 0, 3000)
+	for value := range uint16(3000) {
+		run = append(run, value+100)
+	}
+	bitmap := make([]uint16, 0, 6000)
+	for value := uint16(0); value < 12000; value += 2 {
+		bitmap = append(bitmap, value)
+	}
+	return map[string]roaring_bitmap.Container{
+		string(testKey(0)): roaring_bitmap.FromSortedValues(array),
+		string(testKey(1)): roaring_bitmap.FromSortedValues(run),
+		string(testKey(2)): roaring_bitmap.FromSortedValues(bitmap),
+	}
+}
+
+// inMapping tells if the values of c are in the mapped file of table.
+func inMapping(table *SSTable, c roaring_bitmap.Container) bool {
+	var p unsafe.Pointer
+	switch c := c.(type) {
+	case *roaring_bitmap.Array:
+		p = unsafe.Pointer(unsafe.SliceData(c.Values))
+	case *roaring_bitmap.Run:
+		p = unsafe.Pointer(unsafe.SliceData(c.Values))
+	case *roaring_bitmap.Bitmap:
+		p = unsafe.Pointer(unsafe.SliceData(c.Values.Bytes()))
+	}
+	start := uintptr(unsafe.Pointer(unsafe.SliceData(table.mapped)))
+	return uintptr(p) >= start && uintptr(p) < start+uintptr(len(table.mapped))
+}
+
+func TestSSTable_Map(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("tables are only mapped on Linux")
+	}
+	containers := containerKinds()
+	table, _ := newTestTable(t, containers, NoCompression)
+	require.Len(t, table.index, 1)
+	require.NoError(t, table.Map())
+	require.True(t, table.Mapped())
+
+	var elements []*TableElement
+	for key, expected := range containers {
+		element, err := table.SearchKey([]byte(key))
+		require.NoError(t, err)
+		require.IsType(t, expected, element.Value)
+		require.True(t, inMapping(table, element.Value))
+		elements = append(elements, element)
+	}
+	// iterators read copies, which outlive the table
+	it := table.NewIterator()
+	require.True(t, it.Next())
+	require.False(t, inMapping(table, it.Element().Value))
+	iterated := it.Element()
+
+	// the table stays mapped until the last element is released
+	require.NoError(t, table.Close())
+	require.True(t, table.Mapped())
+	for _, element := range elements {
+		require.Equal(t, containers[string(element.Key)].ConvertToArray().Values, element.Value.ConvertToArray().Values)
+		require.NoError(t, element.Release())
+	}
+	require.False(t, table.Mapped())
+	require.Equal(t, containers[string(iterated.Key)].ConvertToArray().Values, iterated.Value.ConvertToArray().Values)
+
+	// compressed blocks are decompressed into memory of their own
+	table, _ = newTestTable(t, containers, FlateCompression)
+	require.NoError(t, table.Map())
+	for key, expected := range containers {
+		element, err := table.SearchKey([]byte(key))
+		require.NoError(t, err)
+		require.Nil(t, element.table)
+		require.False(t, inMapping(table, element.Value))
+		require.Equal(t, expected.ConvertToArray().Values, element.Value.ConvertToArray().Values)
+		require.NoError(t, element.Release())
+	}
+	require.NoError(t, table.Close())
+	require.False(t, table.Mapped())
+}
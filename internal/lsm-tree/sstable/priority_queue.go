@@ -1,35 +1,39 @@
 package sstable
 
+import "inverted-index/internal/lsm-tree/common"
+
 type mergeItem struct {
-	value      TableElement
-	readerIdx  int
-	elementIdx int64
+	value     TableElement
+	readerIdx int
 }
 
-type priorityQueue []*mergeItem
+type priorityQueue struct {
+	items      []*mergeItem
+	comparator common.Comparator
+}
 
-func (pq priorityQueue) Len() int { return len(pq) }
+func (pq *priorityQueue) Len() int { return len(pq.items) }
 
-func (pq priorityQueue) Less(i, j int) bool {
-	if pq[i].value.Key < pq[j].value.Key {
-		return true
+func (pq *priorityQueue) Less(i, j int) bool {
+	if c := pq.comparator.Compare(pq.items[i].value.Key, pq.items[j].value.Key); c != 0 {
+		return c < 0
 	}
-	return pq[i].value.Key == pq[j].value.Key && pq[i].readerIdx > pq[j].readerIdx
+	return pq.items[i].readerIdx < pq.items[j].readerIdx
 }
 
-func (pq priorityQueue) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
+func (pq *priorityQueue) Swap(i, j int) {
+	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
 }
 
 func (pq *priorityQueue) Push(x interface{}) {
 	element := x.(*mergeItem)
-	*pq = append(*pq, element)
+	pq.items = append(pq.items, element)
 }
 
 func (pq *priorityQueue) Pop() interface{} {
-	old := *pq
+	old := pq.items
 	n := len(old)
 	element := old[n-1]
-	*pq = old[0 : n-1]
+	pq.items = old[0 : n-1]
 	return element
 }
@@ -1,269 +1,520 @@
 package sstable
 
 import (
-	"bufio"
+	"bytes"
 	"container/heap"
-	"encoding/binary"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 
 	"inverted-index/internal/lsm-tree/bloom_filter"
 	"inverted-index/internal/lsm-tree/common"
+	"inverted-index/internal/lsm-tree/merge_operator"
 	roaring_bitmap "inverted-index/internal/roaring-bitmap"
 )
 
 type SearchResult int
 
 type SSTable struct {
-	metaFile    *os.File
-	dataFile    *os.File
-	cookie      *cookieData
-	size        int
+	file       *os.File
+	comparator common.Comparator
+	// size is the number of elements and fileSize is the size of the file in bytes
+	size     int
+	fileSize int
+	// smallest and largest are the first and the last key, nil in an empty table
+	smallest []byte
+	largest  []byte
+	index    []indexEntry
+	// bloomFilter is read from the filter block by the first search of an opened table
+	filterBlock blockHandle
+	loadFilter  sync.Once
 	bloomFilter bloom_filter.BloomFilter
+	filterErr   error
+	// mapped is the file mapped into memory by Map, or nil if it is read with file I/O
+	mapped []byte
+
+	// mu guards refs, removed and closed
+	mu sync.Mutex
+	// refs counts the users of the table besides its tree, e.g. iterators and the elements
+	// searched in a mapped table; a removed table is deleted and a closed one is unmapped
+	// and closed once the last of them is done
+	refs    int
+	removed bool
+	closed  bool
 }
 
-func New(metaFilepath string, dataFilepath string, tablesToMerge []*SSTable) (*SSTable, error) {
-	if len(tablesToMerge) != common.MaxLevelSize {
-		return nil, fmt.Errorf("number of tables to merge is not equal to level size")
-	}
-
-	sizeEstimation := 0
+// Merge merges tablesToMerge (ordered from oldest to newest) into new tables, combining
+// the containers of a key with mergeOperator. Values returned by deleted for a key are
+// merged into its container as a tombstone, and then filter, if not nil, rewrites the
+// container or drops the key by returning nil. The data blocks of the new tables are
+// compressed with compression. A new table is started once the current one reaches
+// maxTableSize bytes, unless it is zero; newPath returns the file path of every new
+// table. The tables hold disjoint key ranges in order, and there are none if nothing
+// is left after the merge.
+func Merge(newPath func() string, tablesToMerge []*SSTable, maxTableSize int, comparator common.Comparator, mergeOperator merge_operator.MergeOperator, deleted func(key []byte) roaring_bitmap.Container, filter func(key []byte, value roaring_bitmap.Container) roaring_bitmap.Container, bloomBitsPerKey int, compression Compression) ([]*SSTable, error) {
+	sizeEstimation, fileSize := 0, 0
 	for _, table := range tablesToMerge {
 		sizeEstimation += table.size
+		fileSize += table.fileSize
 	}
-
-	s := &SSTable{bloomFilter: bloom_filter.New(sizeEstimation)}
-
-	var err error
-	s.metaFile, err = createFile(metaFilepath)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
+	// the elements of a split table are estimated from its share of the merged data
+	if maxTableSize > 0 && fileSize > maxTableSize {
+		sizeEstimation = sizeEstimation*maxTableSize/fileSize + 1
 	}
 
-	s.dataFile, err = createFile(dataFilepath)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
+	m := &merger{
+		newPath:         newPath,
+		maxTableSize:    maxTableSize,
+		comparator:      comparator,
+		mergeOperator:   mergeOperator,
+		deleted:         deleted,
+		filter:          filter,
+		sizeEstimation:  sizeEstimation,
+		bloomBitsPerKey: bloomBitsPerKey,
+		compression:     compression,
 	}
-
-	err = s.merge(tablesToMerge)
-	if err != nil {
+	if err := m.merge(tablesToMerge); err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrMergingTables, err)
 	}
-
-	return s, nil
+	return m.tables, nil
 }
 
-func NewFromMap(metaFilepath string, dataFilepath string, valuesToAdd map[uint16]roaring_bitmap.Container) (*SSTable, error) {
-	s := &SSTable{bloomFilter: bloom_filter.New(common.FirstLevelSize)}
-
-	var err error
-
-	s.metaFile, err = createFile(metaFilepath)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
-	}
-	metaWriter := bufio.NewWriter(s.metaFile)
-	defer metaWriter.Flush()
-
-	s.dataFile, err = createFile(dataFilepath)
+// NewFromMap writes valuesToAdd, keyed by the string of every key, to a new table
+// whose data blocks are compressed with compression.
+func NewFromMap(path string, valuesToAdd map[string]roaring_bitmap.Container, comparator common.Comparator, bloomBitsPerKey int, compression Compression) (*SSTable, error) {
+	w, err := newWriter(path, comparator, bloom_filter.New(len(valuesToAdd), bloomBitsPerKey), compression)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
+		return nil, err
 	}
-	dataWriter := bufio.NewWriter(s.dataFile)
-	defer dataWriter.Flush()
 
 	valuesSorted := make([]TableElement, len(valuesToAdd))
 	i := 0
 	for key, value := range valuesToAdd {
 		valuesSorted[i] = TableElement{
-			Key:   key,
+			Key:   []byte(key),
 			Value: value,
 		}
 		i++
 	}
 	sort.Slice(valuesSorted, func(i, j int) bool {
-		return valuesSorted[i].Key < valuesSorted[j].Key
+		return comparator.Compare(valuesSorted[i].Key, valuesSorted[j].Key) < 0
 	})
 
-	offset := 0
 	for _, value := range valuesSorted {
-		err = s.writeElement(metaWriter, dataWriter, &value, &offset)
-		if err != nil {
+		if err = w.add(&value); err != nil {
 			return nil, err
 		}
 	}
 
+	return w.finish()
+}
+
+// Open opens a table written before, reading its footer and index. The bloom filter
+// is read when the table is searched for the first time.
+func Open(path string, comparator common.Comparator) (*SSTable, error) {
+	s := &SSTable{comparator: comparator}
+
+	var err error
+	s.file, err = os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileOpening, err)
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileOpening, err)
+	}
+	s.fileSize = int(info.Size())
+	if s.fileSize < footerSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrUnknownFormat, s.fileSize)
+	}
+
+	footerBytes := make([]byte, footerSize)
+	if _, err = s.file.ReadAt(footerBytes, int64(s.fileSize-footerSize)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+	f, err := footerFromBytes(footerBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s.filterBlock = f.filter
+	indexBytes, err := s.readBlock(f.index)
+	if err != nil {
+		return nil, err
+	}
+	index, err := indexBlockFromBytes(indexBytes)
+	if err != nil {
+		return nil, err
+	}
+	s.size, s.index, s.largest = index.elements, index.entries, index.largest
+	if len(s.index) > 0 {
+		s.smallest = s.index[0].firstKey
+	}
+
 	return s, nil
 }
 
-func (s *SSTable) SearchKey(key uint16) (*TableElement, error) {
-	keyBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(keyBytes[:], key)
-	if ok, err := s.bloomFilter.CheckContains(keyBytes); err != nil {
+// Name identifies the table among the others in the same directory.
+func (s *SSTable) Name() string {
+	return filepath.Base(s.file.Name())
+}
+
+// Smallest returns the first key of the table, or nil if it is empty.
+func (s *SSTable) Smallest() []byte {
+	return s.smallest
+}
+
+// Largest returns the last key of the table, or nil if it is empty.
+func (s *SSTable) Largest() []byte {
+	return s.largest
+}
+
+// FileSize returns the size of the file of the table in bytes.
+func (s *SSTable) FileSize() int {
+	return s.fileSize
+}
+
+// SearchKey returns the element of key, or nil if the table does not hold it.
+// It reads a single data block, the one the index points to. If the table is mapped,
+// the container of the element may be a view of the mapped file, which the table keeps
+// until the element is released.
+func (s *SSTable) SearchKey(key []byte) (*TableElement, error) {
+	bloomFilter, err := s.filter()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := bloomFilter.CheckContains(key); err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrBloomFilter, err)
 	} else if !ok {
 		return nil, nil
 	}
 
-	left, right := -1, s.size
-	for right-left > 1 {
-		mid := (left + right) / 2
-		midValue, err := tableElementFromFileRandom(s.metaFile, s.dataFile, s.cookie, int64(mid))
+	block := s.blockFor(key)
+	if block < 0 {
+		return nil, nil
+	}
+	s.Ref()
+	element, err := s.searchBlock(s.index[block].handle, key)
+	if element == nil || element.table == nil {
+		if unrefErr := s.Unref(); err == nil {
+			err = unrefErr
+		}
+	}
+	return element, err
+}
+
+func (s *SSTable) searchBlock(handle blockHandle, key []byte) (*TableElement, error) {
+	b, mapped, err := s.viewBlock(handle)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{b: b, mapped: mapped}
+	if err = s.seek(d, key); err != nil || d.done() {
+		return nil, err
+	}
+	element, err := elementFromBlock(d, true)
+	if err != nil || s.comparator.Compare(element.Key, key) != 0 {
+		return nil, err
+	}
+	// the decoded key refers to the block, but only the container is kept with the table
+	element.Key = key
+	if mapped {
+		element.table = s
+	}
+	return element, nil
+}
+
+func (s *SSTable) filter() (bloom_filter.BloomFilter, error) {
+	s.loadFilter.Do(func() {
+		if s.bloomFilter != nil {
+			return
+		}
+		b, err := s.readBlock(s.filterBlock)
 		if err != nil {
-			return nil, err
+			s.filterErr = err
+			return
 		}
-		if midValue.Key == key {
-			return midValue, nil
-		} else if midValue.Key < key {
-			left = mid
-		} else {
-			right = mid
+		s.bloomFilter, s.filterErr = bloomFilterFromBytes(b)
+	})
+	return s.bloomFilter, s.filterErr
+}
+
+func bloomFilterFromBytes(b []byte) (bloom_filter.BloomFilter, error) {
+	bloomFilter, err := bloom_filter.FromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w: %w", ErrCorruption, ErrBloomFilter, err)
+	}
+	return bloomFilter, nil
+}
+
+// blockFor returns -1 if key is less than every key of the table.
+func (s *SSTable) blockFor(key []byte) int {
+	return sort.Search(len(s.index), func(i int) bool {
+		return s.comparator.Compare(s.index[i].firstKey, key) > 0
+	}) - 1
+}
+
+func (s *SSTable) seek(d *decoder, key []byte) error {
+	for !d.done() {
+		next := *d
+		element, err := elementFromBlock(&next, false)
+		if err != nil {
+			return err
+		}
+		if s.comparator.Compare(element.Key, key) >= 0 {
+			return nil
 		}
+		*d = next
 	}
+	return nil
+}
 
-	return nil, nil
+// readBlock copies the block even if the table is mapped, so it outlives the table.
+func (s *SSTable) readBlock(handle blockHandle) ([]byte, error) {
+	b, mapped, err := s.viewBlock(handle)
+	if mapped {
+		return bytes.Clone(b), nil
+	}
+	return b, err
 }
 
-func (s *SSTable) Close() error {
-	err := s.metaFile.Close()
+// viewBlock returns uncompressed blocks of a mapped table in place, as told by mapped.
+func (s *SSTable) viewBlock(handle blockHandle) (b []byte, mapped bool, err error) {
+	if s.mapped == nil {
+		b, err = readBlock(s.file, int64(s.fileSize), handle)
+		return b, false, err
+	}
+	if err = checkHandle(int64(s.fileSize), handle); err != nil {
+		return nil, false, err
+	}
+	stored, err := checkBlock(s.mapped[handle.offset:handle.offset+uint64(handle.size)+blockTrailerSize], handle.offset)
 	if err != nil {
-		return fmt.Errorf("%w: %w", ErrFileClosing, err)
+		return nil, false, err
+	}
+	if b, err = decompressBlock(stored, handle.offset); err != nil {
+		return nil, false, err
+	}
+	return b, Compression(stored[0]) == NoCompression, nil
+}
+
+func checkHandle(fileSize int64, handle blockHandle) error {
+	if handle.offset+uint64(handle.size)+blockTrailerSize > uint64(fileSize) {
+		return fmt.Errorf("%w: the block at %d of %d bytes is past the end of the file",
+			ErrCorruption, handle.offset, handle.size)
 	}
+	return nil
+}
 
-	err = s.dataFile.Close()
+func readBlock(file *os.File, fileSize int64, handle blockHandle) ([]byte, error) {
+	if err := checkHandle(fileSize, handle); err != nil {
+		return nil, err
+	}
+	b := make([]byte, int(handle.size)+blockTrailerSize)
+	if _, err := file.ReadAt(b, int64(handle.offset)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+	contents, err := checkBlock(b, handle.offset)
 	if err != nil {
+		return nil, err
+	}
+	return decompressBlock(contents, handle.offset)
+}
+
+// Close closes the file of the table, or marks it to be closed by the last Unref if it is
+// referenced, so that the mapped file stays valid for the elements not released yet.
+func (s *SSTable) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.refs > 0 {
+		return nil
+	}
+	return s.close()
+}
+
+func (s *SSTable) close() error {
+	if s.mapped != nil {
+		if err := munmap(s.mapped); err != nil {
+			return fmt.Errorf("%w: %w", ErrFileClosing, err)
+		}
+		s.mapped = nil
+	}
+	if err := s.file.Close(); err != nil {
 		return fmt.Errorf("%w: %w", ErrFileClosing, err)
 	}
+	return nil
+}
+
+// Ref keeps the files of the table until the matching Unref, even if it is removed or closed meanwhile.
+func (s *SSTable) Ref() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	s.refs++
+}
+
+// Unref releases a reference taken with Ref, deleting the table if it was removed
+// or closing it if it was closed.
+func (s *SSTable) Unref() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refs--
+	if s.refs == 0 && s.removed {
+		return s.delete()
+	}
+	if s.refs == 0 && s.closed {
+		return s.close()
+	}
 	return nil
 }
 
+// Remove deletes the table, or marks it to be deleted by the last Unref if it is referenced.
 func (s *SSTable) Remove() error {
-	err := s.Close()
-	if err != nil {
-		return err
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	err = os.Remove(s.metaFile.Name())
-	if err != nil {
-		return err
+	s.removed = true
+	if s.refs > 0 {
+		return nil
 	}
+	return s.delete()
+}
 
-	err = os.Remove(s.dataFile.Name())
+func (s *SSTable) delete() error {
+	err := s.close()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return os.Remove(s.file.Name())
 }
 
-func (s *SSTable) merge(tablesToMerge []*SSTable) error {
-	queue := priorityQueue{}
-	heap.Init(&queue)
-
-	metaWriter := bufio.NewWriter(s.metaFile)
-	defer metaWriter.Flush()
-	dataWriter := bufio.NewWriter(s.dataFile)
-	defer dataWriter.Flush()
+type merger struct {
+	newPath         func() string
+	maxTableSize    int
+	comparator      common.Comparator
+	mergeOperator   merge_operator.MergeOperator
+	deleted         func(key []byte) roaring_bitmap.Container
+	filter          func(key []byte, value roaring_bitmap.Container) roaring_bitmap.Container
+	sizeEstimation  int
+	bloomBitsPerKey int
+	compression     Compression
+
+	tables []*SSTable
+	// w writes the current table, nil before the first one
+	w *writer
+}
 
-	metaReaders := make([]*bufio.Reader, len(tablesToMerge))
-	dataReaders := make([]*bufio.Reader, len(tablesToMerge))
+func (m *merger) merge(tablesToMerge []*SSTable) error {
+	queue := &priorityQueue{comparator: m.comparator}
+	heap.Init(queue)
 
-	for i := 0; i < len(tablesToMerge); i++ {
-		if _, err := setDataFileOffset(tablesToMerge[i].metaFile, tablesToMerge[i].dataFile, s.cookie, 0, true); err != nil {
-			return err
+	iterators := make([]*Iterator, len(tablesToMerge))
+	next := func(i int) error {
+		if iterators[i].Next() {
+			heap.Push(queue, &mergeItem{value: *iterators[i].Element(), readerIdx: i})
 		}
-		metaReaders[i] = bufio.NewReader(tablesToMerge[i].metaFile)
-		dataReaders[i] = bufio.NewReader(tablesToMerge[i].dataFile)
-
-		element, err := tableElementFromFileConsecutive(metaReaders[i], dataReaders[i], s.cookie, 0)
-		if err != nil {
+		return iterators[i].Err()
+	}
+	for i, table := range tablesToMerge {
+		iterators[i] = table.NewIterator()
+		if err := next(i); err != nil {
 			return err
 		}
-		heap.Push(&queue, &mergeItem{
-			value:      *element,
-			readerIdx:  i,
-			elementIdx: 0,
-		})
 	}
 
 	var toInsert *TableElement
-	offset := 0
 	for queue.Len() > 0 {
-		element := heap.Pop(&queue).(*mergeItem)
+		element := heap.Pop(queue).(*mergeItem)
 
-		if offset == 0 {
+		if toInsert == nil {
 			toInsert = &element.value
-		} else if toInsert.Key == element.value.Key {
-			toInsert.Value = roaring_bitmap.Or(toInsert.Value, element.value.Value)
+		} else if m.comparator.Compare(toInsert.Key, element.value.Key) == 0 {
+			// equal keys are popped from the oldest table to the newest
+			toInsert.Value = m.mergeOperator.PartialMerge(
+				toInsert.Key,
+				merge_operator.Operand{Value: toInsert.Value},
+				merge_operator.Operand{Value: element.value.Value},
+			).Value
 		} else {
-			err := s.writeElement(metaWriter, dataWriter, toInsert, &offset)
+			err := m.writeMergedElement(toInsert)
 			if err != nil {
 				return fmt.Errorf("%w: %w", ErrWritingElement, err)
 			}
 			toInsert = &element.value
 		}
 
-		newElement, err := tableElementFromFileConsecutive(metaReaders[element.readerIdx], dataReaders[element.readerIdx], s.cookie, element.elementIdx+1)
-		if err != nil && err != io.EOF {
+		if err := next(element.readerIdx); err != nil {
 			return err
 		}
-		if err != io.EOF {
-			heap.Push(&queue, &mergeItem{
-				value:     *newElement,
-				readerIdx: element.readerIdx,
-			})
-		}
-
 	}
-	err := s.writeElement(metaWriter, dataWriter, toInsert, &offset)
-	if err != nil {
-		return fmt.Errorf("%w: %w", ErrWritingElement, err)
+	if toInsert != nil {
+		err := m.writeMergedElement(toInsert)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrWritingElement, err)
+		}
 	}
 
-	return nil
+	if m.w == nil {
+		return nil
+	}
+	return m.finishTable()
 }
 
-func (s *SSTable) writeElement(metaDataWriter *bufio.Writer, dataWriter *bufio.Writer, element *TableElement, offset *int) error {
-	elementBytes, err := element.toBytes()
-	if err != nil {
-		return err
+func (m *merger) writeMergedElement(element *TableElement) error {
+	if tombstone := m.deleted(element.Key); tombstone != nil {
+		element.Value = m.mergeOperator.PartialMerge(
+			element.Key,
+			merge_operator.Operand{Value: element.Value},
+			merge_operator.Operand{Value: tombstone, Tombstone: true},
+		).Value
 	}
-	if _, err = dataWriter.Write(elementBytes); err != nil {
-		return err
+	if m.filter != nil && element.Value != nil {
+		element.Value = m.filter(element.Key, element.Value)
+	}
+	if element.Value == nil {
+		return nil
 	}
 
-	elementMetaData := meta{
-		key:         element.Key,
-		cardinality: element.Value.GetCardinality(),
-		offset:      uint32(*offset),
+	if m.w == nil || (m.maxTableSize > 0 && m.w.size() >= m.maxTableSize) {
+		if m.w != nil {
+			if err := m.finishTable(); err != nil {
+				return err
+			}
+		}
+		if err := m.startTable(); err != nil {
+			return err
+		}
 	}
-	elementMetaDataBytes, err := elementMetaData.toBytes()
+	return m.w.add(element)
+}
+
+func (m *merger) startTable() error {
+	w, err := newWriter(m.newPath(), m.comparator, bloom_filter.New(m.sizeEstimation, m.bloomBitsPerKey), m.compression)
 	if err != nil {
 		return err
 	}
-	_, err = metaDataWriter.Write(elementMetaDataBytes)
+	m.w = w
+	return nil
+}
+
+func (m *merger) finishTable() error {
+	table, err := m.w.finish()
 	if err != nil {
 		return err
 	}
+	m.tables = append(m.tables, table)
+	return nil
+}
 
-	keyBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(keyBytes[:], element.Key)
-	err = s.bloomFilter.Add(keyBytes)
-	if err != nil {
+func (s *SSTable) addToBloomFilter(key []byte) error {
+	if err := s.bloomFilter.Add(key); err != nil {
 		return fmt.Errorf("%w: %w", ErrBloomFilter, err)
 	}
-
-	switch element.Value.(type) {
-	case *roaring_bitmap.Run:
-		s.cookie.runFlagBitset.Set(uint(s.size))
-	}
-	s.size++
-	*offset += len(elementBytes)
-
 	return nil
 }
 
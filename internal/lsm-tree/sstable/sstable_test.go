@@ -0,0 +1,104 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"inverted-index/internal/lsm-tree/bloom_filter"
+	"inverted-index/internal/lsm-tree/common"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+func testKey(n int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(n))
+}
+
+// newTestTable writes containers to a new table and opens it again, so that it is read
+// like a table written before, with its index and filter decoded from the file.
+func newTestTable(t *testing.T, containers map[string]roaring_bitmap.Container, compression Compression) (*SSTable, string) {
+	path := filepath.Join(t.TempDir(), "table")
+	table, err := NewFromMap(path, containers, common.BytewiseComparator{}, bloom_filter.DefaultBitsPerKey, compression)
+	require.NoError(t, err)
+	require.NoError(t, table.Close())
+	table, err = Open(path, common.BytewiseComparator{})
+	require.NoError(t, err)
+	return table, path
+}
+
+// evenKeys returns containers of the even keys less than n, holding the key and the next number.
+func evenKeys(n int) map[string]roaring_bitmap.Container {
+	containers := make(map[string]roaring_bitmap.Container)
+	for key := 0; key < n; key += 2 {
+		containers[string(testKey(key))] = roaring_bitmap.FromSortedValues([]uint16{uint16(key), uint16(key) + 1})
+	}
+	return containers
+}
+
+func searchValues(t *testing.T, table *SSTable, key []byte) []uint16 {
+	element, err := table.SearchKey(key)
+	require.NoError(t, err)
+	if element == nil {
+		return nil
+	}
+	defer func() { require.NoError(t, element.Release()) }()
+	return element.Value.ConvertToArray().Values
+}
+
+func TestSSTable_BlockBoundaries(t *testing.T) {
+	// the odd keys are missing, so every block starts and ends next to keys it does not hold
+	table, _ := newTestTable(t, evenKeys(2000), NoCompression)
+	require.Greater(t, len(table.index), 2)
+	require.Equal(t, 1000, table.size)
+	require.Equal(t, testKey(0), table.Smallest())
+	require.Equal(t, testKey(1998), table.Largest())
+
+	for key := range 2000 {
+		if key%2 == 1 {
+			require.Nil(t, searchValues(t, table, testKey(key)), key)
+		} else {
+			require.Equal(t, []uint16{uint16(key), uint16(key) + 1}, searchValues(t, table, testKey(key)), key)
+		}
+	}
+	require.Nil(t, searchValues(t, table, []byte{0}))
+	require.Nil(t, searchValues(t, table, testKey(5000)))
+
+	// seeking to a block boundary, or to the missing key before it, moves to its first key
+	it := table.NewIterator()
+	for _, entry := range table.index[1:] {
+		first := int(binary.BigEndian.Uint32(entry.firstKey))
+		for _, key := range []int{first - 1, first} {
+			it.Seek(testKey(key))
+			require.True(t, it.Next())
+			require.Equal(t, entry.firstKey, it.Element().Key)
+		}
+		// the last key of the previous block
+		it.Seek(testKey(first - 2))
+		require.True(t, it.Next())
+		require.Equal(t, testKey(first-2), it.Element().Key)
+		require.True(t, it.Next())
+		require.Equal(t, entry.firstKey, it.Element().Key)
+	}
+
+	it.Seek(nil)
+	for key := 0; key < 2000; key += 2 {
+		require.True(t, it.Next())
+		require.Equal(t, testKey(key), it.Element().Key)
+	}
+	require.False(t, it.Next())
+	require.NoError(t, it.Err())
+	require.NoError(t, table.Close())
+}
+
+func TestSSTable_Empty(t *testing.T) {
+	table, _ := newTestTable(t, map[string]roaring_bitmap.Container{}, NoCompression)
+	require.Nil(t, table.Smallest())
+	require.Nil(t, table.Largest())
+	require.Nil(t, searchValues(t, table, testKey(0)))
+	it := table.NewIterator()
+	require.False(t, it.Next())
+	require.NoError(t, it.Err())
+	require.NoError(t, table.Close())
+}
@@ -1,12 +1,8 @@
 package sstable
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/binary"
 	"fmt"
-	"io"
-	"os"
 
 	"github.com/bits-and-blooms/bitset"
 
@@ -14,129 +10,103 @@ import (
 )
 
 type TableElement struct {
-	Key   uint16
+	Key   []byte
 	Value roaring_bitmap.Container
+	// table is referenced while Value may be a view of its mapped file
+	table *SSTable
 }
 
-func (e *TableElement) toBytes() ([]byte, error) {
-	buf := new(bytes.Buffer)
-
-	if _, err := buf.Write(e.Value.SerializeValues()); err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+// Release lets the mapped table the element was searched in be unmapped, once it is closed,
+// or deleted, once it is removed, after which the container must not be used anymore. It has
+// to be called for the elements SearchKey returns from mapped tables and does nothing for others.
+func (e *TableElement) Release() error {
+	if e.table == nil {
+		return nil
 	}
-
-	return buf.Bytes(), nil
+	table := e.table
+	e.table = nil
+	return table.Unref()
 }
 
-func tableElementFromFileRandom(metaFile *os.File, dataFile *os.File, cookie *cookieData, elementIdx int64) (*TableElement, error) {
-	elementMeta, err := setDataFileOffset(metaFile, dataFile, cookie, elementIdx, false)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrSetFileOffset, err)
-	}
-
-	dataReader := bufio.NewReader(dataFile)
-	element, err := tableElementFromBytes(dataReader, cookie, elementIdx, elementMeta)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+// appendTo tells arrays and bitmaps apart only by cardinality, so the container type has to match it.
+func (e *TableElement) appendTo(b []byte) []byte {
+	b = appendKey(b, e.Key)
+	b = binary.LittleEndian.AppendUint16(b, e.Value.GetCardinality())
+	if r, ok := e.Value.(*roaring_bitmap.Run); ok {
+		b = append(b, 1)
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(r.Values)))
+	} else {
+		b = append(b, 0)
 	}
-
-	return element, nil
+	b = append(b, make([]byte, padding(len(b), valuesAlignment(e.Value)))...)
+	return append(b, e.Value.SerializeValues()...)
 }
 
-func tableElementFromFileConsecutive(metaReader *bufio.Reader, dataReader *bufio.Reader, cookie *cookieData, elementIdx int64) (*TableElement, error) {
-	elementMeta, err := metaFromBytes(metaReader)
-	if err != nil {
-		return nil, err
-	}
-
-	element, err := tableElementFromBytes(dataReader, cookie, elementIdx, elementMeta)
-	if err != nil {
-		return nil, err
+func valuesAlignment(c roaring_bitmap.Container) int {
+	if _, ok := c.(*roaring_bitmap.Bitmap); ok {
+		return 8
 	}
-
-	return element, nil
+	return 2
 }
 
-func tableElementFromBytes(reader io.Reader, cookie *cookieData, elementIdx int64, elementMeta *meta) (*TableElement, error) {
-	if cookie.isRunContainer(uint16(elementIdx)) {
-		var runCount uint16
-		if err := binary.Read(reader, binary.LittleEndian, &runCount); err != nil {
-			return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+func elementFromBlock(d *decoder, withValue bool) (*TableElement, error) {
+	element := &TableElement{Key: keyFromBytes(d)}
+	// cardinality is stored decremented by one, like in the containers
+	cardinality := d.uint16()
+	run := d.uint8() != 0
+
+	switch {
+	case run:
+		runCount := int(d.uint16())
+		d.align(2)
+		values := d.next(runCount * 4)
+		if !withValue || values == nil {
+			break
 		}
-
-		values := make([]roaring_bitmap.RunRecord, runCount)
-		for i := 0; i < int(runCount); i++ {
-			if err := binary.Read(reader, binary.LittleEndian, &values[i].Start); err != nil {
-				return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
-			}
-			if err := binary.Read(reader, binary.LittleEndian, &values[i].Length); err != nil {
-				return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
-			}
+		if records := viewRunRecords(d, values); records != nil {
+			element.Value = &roaring_bitmap.Run{Cardinality: cardinality, Values: records}
+			break
 		}
-
-		return &TableElement{
-			Key: elementMeta.key,
-			Value: &roaring_bitmap.Run{
-				Cardinality: elementMeta.cardinality,
-				Values:      values,
-			},
-		}, nil
-	} else if elementMeta.cardinality <= roaring_bitmap.MaxArraySize {
-		values := make([]uint16, elementMeta.cardinality)
-		for i := 0; i < int(elementMeta.cardinality); i++ {
-			if err := binary.Read(reader, binary.LittleEndian, &values[i]); err != nil {
-				return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
-			}
+		records := make([]roaring_bitmap.RunRecord, runCount)
+		for i := range records {
+			records[i].Start = binary.LittleEndian.Uint16(values[i*4:])
+			records[i].Length = binary.LittleEndian.Uint16(values[i*4+2:])
 		}
-
-		return &TableElement{
-			Key: elementMeta.key,
-			Value: &roaring_bitmap.Array{
-				Cardinality: elementMeta.cardinality,
-				Values:      values,
-			},
-		}, nil
-	} else {
-		uint64s := make([]uint64, roaring_bitmap.BitmapWordsSize)
-		for i := 0; i < roaring_bitmap.BitmapWordsSize; i++ {
-			if err := binary.Read(reader, binary.LittleEndian, &uint64s[i]); err != nil {
-				return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
-			}
+		element.Value = &roaring_bitmap.Run{Cardinality: cardinality, Values: records}
+	case cardinality <= roaring_bitmap.MaxArraySize:
+		d.align(2)
+		values := d.next((int(cardinality) + 1) * 2)
+		if !withValue || values == nil {
+			break
 		}
-
-		return &TableElement{
-			Key: elementMeta.key,
-			Value: &roaring_bitmap.Bitmap{
-				Cardinality: elementMeta.cardinality,
-				Values:      bitset.From(uint64s),
-			},
-		}, nil
-	}
-}
-
-func setDataFileOffset(metaFile *os.File, dataFile *os.File, cookie *cookieData, elementIdx int64, setCorrectMetaOffset bool) (*meta, error) {
-	err := setMetaFileOffset(metaFile, cookie, elementIdx)
-	if err != nil {
-		return nil, err
-	}
-
-	metaReader := bufio.NewReader(metaFile)
-	elementMeta, err := metaFromBytes(metaReader)
-	if err != nil {
-		return nil, err
-	}
-
-	_, err = dataFile.Seek(int64(elementMeta.offset), 0)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrFileSeeking, err)
-	}
-
-	if setCorrectMetaOffset {
-		err = setMetaFileOffset(metaFile, cookie, elementIdx)
-		if err != nil {
-			return nil, err
+		if array := viewUint16s(d, values); array != nil {
+			element.Value = &roaring_bitmap.Array{Cardinality: cardinality, Values: array}
+			break
+		}
+		array := make([]uint16, int(cardinality)+1)
+		for i := range array {
+			array[i] = binary.LittleEndian.Uint16(values[i*2:])
+		}
+		element.Value = &roaring_bitmap.Array{Cardinality: cardinality, Values: array}
+	default:
+		d.align(8)
+		values := d.next(roaring_bitmap.BitmapWordsSize * 8)
+		if !withValue || values == nil {
+			break
+		}
+		if words := viewUint64s(d, values); words != nil {
+			element.Value = &roaring_bitmap.Bitmap{Cardinality: cardinality, Values: bitset.From(words)}
+			break
 		}
+		words := make([]uint64, roaring_bitmap.BitmapWordsSize)
+		for i := range words {
+			words[i] = binary.LittleEndian.Uint64(values[i*8:])
+		}
+		element.Value = &roaring_bitmap.Bitmap{Cardinality: cardinality, Values: bitset.From(words)}
 	}
 
-	return elementMeta, nil
+	if d.err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorruption, d.err)
+	}
+	return element, nil
 }
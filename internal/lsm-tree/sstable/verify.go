@@ -0,0 +1,116 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Corruption is a damaged part of a table file.
+type Corruption struct {
+	// Offset is where the damaged block or footer starts in the file
+	Offset int64
+	// Err tells what is wrong; it wraps ErrCorruption or ErrUnknownFormat
+	Err error
+}
+
+func (c Corruption) Error() string {
+	return fmt.Sprintf("offset %d: %v", c.Offset, c.Err)
+}
+
+func (c Corruption) Unwrap() error {
+	return c.Err
+}
+
+// VerifyTable reads the whole table file at path, checking the checksum and the
+// encoding of every block, and returns the damaged parts it finds. Data blocks can
+// only be found through the index, so none are checked if the footer or the index
+// block is damaged. The error is only about failing to read the file.
+func VerifyTable(path string) ([]Corruption, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileOpening, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileOpening, err)
+	}
+	fileSize := info.Size()
+	if fileSize < footerSize {
+		return []Corruption{{Offset: 0, Err: fmt.Errorf("%w: %d bytes", ErrUnknownFormat, fileSize)}}, nil
+	}
+
+	var corruptions []Corruption
+	// check reports a damaged block, telling it from an I/O error
+	check := func(offset uint64, err error) (bool, error) {
+		if errors.Is(err, ErrCorruption) || errors.Is(err, ErrUnknownFormat) {
+			corruptions = append(corruptions, Corruption{Offset: int64(offset), Err: err})
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	footerOffset := uint64(fileSize - footerSize)
+	footerBytes := make([]byte, footerSize)
+	if _, err = file.ReadAt(footerBytes, int64(footerOffset)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+	f, err := footerFromBytes(footerBytes)
+	if ok, err := check(footerOffset, err); !ok {
+		return corruptions, err
+	}
+
+	filterBytes, err := readBlock(file, fileSize, f.filter)
+	if ok, err := check(f.filter.offset, err); err != nil {
+		return nil, err
+	} else if ok {
+		if _, err = bloomFilterFromBytes(filterBytes); err != nil {
+			corruptions = append(corruptions, Corruption{Offset: int64(f.filter.offset), Err: err})
+		}
+	}
+
+	indexBytes, err := readBlock(file, fileSize, f.index)
+	var index *indexBlock
+	if err == nil {
+		index, err = indexBlockFromBytes(indexBytes)
+	}
+	if ok, err := check(f.index.offset, err); !ok {
+		return corruptions, err
+	}
+
+	elements := 0
+	for _, entry := range index.entries {
+		b, err := readBlock(file, fileSize, entry.handle)
+		if ok, err := check(entry.handle.offset, err); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+
+		d := &decoder{b: b}
+		for j := 0; !d.done(); j++ {
+			element, err := elementFromBlock(d, true)
+			if err != nil {
+				corruptions = append(corruptions, Corruption{Offset: int64(entry.handle.offset), Err: err})
+				break
+			}
+			if j == 0 && !bytes.Equal(element.Key, entry.firstKey) {
+				corruptions = append(corruptions, Corruption{
+					Offset: int64(entry.handle.offset),
+					Err:    fmt.Errorf("%w: the block does not start with its key in the index", ErrCorruption),
+				})
+			}
+			elements++
+		}
+	}
+	if len(corruptions) == 0 && elements != index.elements {
+		corruptions = append(corruptions, Corruption{
+			Offset: int64(f.index.offset),
+			Err:    fmt.Errorf("%w: %d elements in the index, %d in the blocks", ErrCorruption, index.elements, elements),
+		})
+	}
+
+	return corruptions, nil
+}
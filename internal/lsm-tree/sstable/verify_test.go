@@ -0,0 +1,144 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"inverted-index/internal/lsm-tree/common"
+)
+
+// flipByte inverts the byte at offset of the file at path, from its end if offset is negative.
+func flipByte(t *testing.T, path string, offset int64) {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	if offset < 0 {
+		offset += int64(len(b))
+	}
+	b[offset] ^= 0xff
+	require.NoError(t, os.WriteFile(path, b, 0660))
+}
+
+// rewriteBlock changes the block located by handle, starting with its compression, and
+// updates its checksum, so that only decoding the block finds the damage.
+func rewriteBlock(t *testing.T, path string, handle blockHandle, change func(block []byte)) {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	block := b[handle.offset : handle.offset+uint64(handle.size)]
+	change(block)
+	binary.LittleEndian.PutUint32(b[handle.offset+uint64(handle.size):], crc32.Checksum(block, checksumTable))
+	require.NoError(t, os.WriteFile(path, b, 0660))
+}
+
+func TestVerifyTable_DataBlock(t *testing.T) {
+	table, path := newTestTable(t, evenKeys(2000), NoCompression)
+	second := table.index[1]
+	require.NoError(t, table.Close())
+
+	corruptions, err := VerifyTable(path)
+	require.NoError(t, err)
+	require.Empty(t, corruptions)
+
+	flipByte(t, path, int64(second.handle.offset)+int64(second.handle.size)/2)
+	corruptions, err = VerifyTable(path)
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.Equal(t, int64(second.handle.offset), corruptions[0].Offset)
+	require.ErrorIs(t, corruptions[0], ErrCorruption)
+
+	table, err = Open(path, common.BytewiseComparator{})
+	require.NoError(t, err)
+	_, err = table.SearchKey(second.firstKey)
+	require.ErrorIs(t, err, ErrCorruption)
+	// the blocks around it are still read
+	first := int(binary.BigEndian.Uint32(second.firstKey))
+	require.Equal(t, []uint16{uint16(first - 2), uint16(first - 1)}, searchValues(t, table, testKey(first-2)))
+	third := int(binary.BigEndian.Uint32(table.index[2].firstKey))
+	require.Equal(t, []uint16{uint16(third), uint16(third + 1)}, searchValues(t, table, testKey(third)))
+
+	// an iterator stops at the damaged block
+	it := table.NewIterator()
+	for key := 0; key < first; key += 2 {
+		require.True(t, it.Next())
+		require.Equal(t, testKey(key), it.Element().Key)
+	}
+	require.False(t, it.Next())
+	require.ErrorIs(t, it.Err(), ErrCorruption)
+	require.NoError(t, table.Close())
+}
+
+func TestVerifyTable_BlockEncoding(t *testing.T) {
+	table, path := newTestTable(t, evenKeys(2000), NoCompression)
+	second := table.index[1]
+	require.NoError(t, table.Close())
+
+	// the checksum matches, but the first key runs past the end of the block
+	rewriteBlock(t, path, second.handle, func(block []byte) {
+		binary.LittleEndian.PutUint16(block[1:], 0xffff)
+	})
+	corruptions, err := VerifyTable(path)
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.Equal(t, int64(second.handle.offset), corruptions[0].Offset)
+	require.ErrorIs(t, corruptions[0], ErrCorruption)
+
+	table, err = Open(path, common.BytewiseComparator{})
+	require.NoError(t, err)
+	_, err = table.SearchKey(second.firstKey)
+	require.ErrorIs(t, err, ErrCorruption)
+	require.Equal(t, []uint16{0, 1}, searchValues(t, table, testKey(0)))
+	require.NoError(t, table.Close())
+
+	// the block decodes, but its first key is not the one of the index
+	table, path = newTestTable(t, evenKeys(2000), NoCompression)
+	second = table.index[1]
+	require.NoError(t, table.Close())
+	rewriteBlock(t, path, second.handle, func(block []byte) {
+		block[1+2+len(second.firstKey)-1]++
+	})
+	corruptions, err = VerifyTable(path)
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.ErrorIs(t, corruptions[0], ErrCorruption)
+
+	// a block of an unknown compression
+	table, path = newTestTable(t, evenKeys(2000), NoCompression)
+	second = table.index[1]
+	require.NoError(t, table.Close())
+	rewriteBlock(t, path, second.handle, func(block []byte) {
+		block[0] = byte(FlateCompression + 1)
+	})
+	corruptions, err = VerifyTable(path)
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.ErrorIs(t, corruptions[0], ErrUnknownFormat)
+}
+
+func TestVerifyTable_Footer(t *testing.T) {
+	table, path := newTestTable(t, evenKeys(2000), NoCompression)
+	require.NoError(t, table.Close())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	flipByte(t, path, -footerSize)
+	_, err = Open(path, common.BytewiseComparator{})
+	require.ErrorIs(t, err, ErrCorruption)
+	corruptions, err := VerifyTable(path)
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.Equal(t, info.Size()-footerSize, corruptions[0].Offset)
+	require.ErrorIs(t, corruptions[0], ErrCorruption)
+
+	// a file too short to hold a footer is not a table
+	require.NoError(t, os.Truncate(path, footerSize-1))
+	_, err = Open(path, common.BytewiseComparator{})
+	require.ErrorIs(t, err, ErrUnknownFormat)
+	corruptions, err = VerifyTable(path)
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.Equal(t, int64(0), corruptions[0].Offset)
+	require.ErrorIs(t, corruptions[0], ErrUnknownFormat)
+}
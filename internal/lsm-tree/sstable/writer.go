@@ -0,0 +1,139 @@
+package sstable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"inverted-index/internal/lsm-tree/bloom_filter"
+	"inverted-index/internal/lsm-tree/common"
+	roaring_bitmap "inverted-index/internal/roaring-bitmap"
+)
+
+type writer struct {
+	table *SSTable
+	out   *bufio.Writer
+	// offset is where the next block starts in the file
+	offset int
+	// block is the data block being filled, starting with the key blockFirstKey
+	block         []byte
+	blockFirstKey []byte
+	index         []indexEntry
+	// compression is the one of the data blocks; the filter and index blocks are not compressed
+	compression Compression
+	compressor  compressor
+}
+
+func newWriter(path string, comparator common.Comparator, bloomFilter bloom_filter.BloomFilter, compression Compression) (*writer, error) {
+	file, err := createFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
+	}
+	return &writer{
+		table:       &SSTable{file: file, comparator: comparator, bloomFilter: bloomFilter},
+		out:         bufio.NewWriter(file),
+		compression: compression,
+	}, nil
+}
+
+func (w *writer) add(element *TableElement) error {
+	if _, ok := element.Value.(*roaring_bitmap.Run); !ok {
+		if element.Value.GetCardinality() <= roaring_bitmap.MaxArraySize {
+			element.Value = element.Value.ConvertToArray()
+		} else {
+			element.Value = element.Value.ConvertToBitmap()
+		}
+	}
+
+	if len(w.block) == 0 {
+		w.blockFirstKey = element.Key
+	}
+	w.block = element.appendTo(w.block)
+	if err := w.table.addToBloomFilter(element.Key); err != nil {
+		return err
+	}
+
+	s := w.table
+	if s.size == 0 {
+		s.smallest = element.Key
+	}
+	s.largest = element.Key
+	s.size++
+
+	if len(w.block) >= blockSize {
+		return w.finishBlock()
+	}
+	return nil
+}
+
+func (w *writer) size() int {
+	return w.offset + len(w.block)
+}
+
+func (w *writer) finishBlock() error {
+	// the contents follow the compression byte
+	if err := w.write(make([]byte, padding(w.offset+1, blockAlignment))); err != nil {
+		return err
+	}
+	handle, err := w.writeBlock(w.block, w.compression)
+	if err != nil {
+		return err
+	}
+	w.index = append(w.index, indexEntry{firstKey: w.blockFirstKey, handle: handle})
+	w.block = w.block[:0]
+	return nil
+}
+
+func (w *writer) writeBlock(block []byte, compression Compression) (blockHandle, error) {
+	stored, err := w.compressor.compress(block, compression)
+	if err != nil {
+		return blockHandle{}, err
+	}
+	handle := blockHandle{offset: uint64(w.offset), size: uint32(len(stored))}
+	if err = w.write(stored); err != nil {
+		return handle, err
+	}
+	return handle, w.write(binary.LittleEndian.AppendUint32(nil, crc32.Checksum(stored, checksumTable)))
+}
+
+func (w *writer) write(b []byte) error {
+	if _, err := w.out.Write(b); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+	w.offset += len(b)
+	return nil
+}
+
+func (w *writer) finish() (*SSTable, error) {
+	if len(w.block) > 0 {
+		if err := w.finishBlock(); err != nil {
+			return nil, err
+		}
+	}
+
+	s := w.table
+	var f footer
+	var err error
+	if f.filter, err = w.writeBlock(s.bloomFilter.Bytes(), NoCompression); err != nil {
+		return nil, err
+	}
+	index := indexBlock{elements: s.size, entries: w.index, largest: s.largest}
+	if f.index, err = w.writeBlock(index.toBytes(), NoCompression); err != nil {
+		return nil, err
+	}
+	if err = w.write(f.toBytes()); err != nil {
+		return nil, err
+	}
+	if err = w.out.Flush(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+	// the table has to be durable before anything refers to it
+	if err = s.file.Sync(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+
+	s.index = w.index
+	s.fileSize = w.offset
+	return s, nil
+}
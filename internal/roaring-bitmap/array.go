@@ -2,6 +2,7 @@ package roaring_bitmap
 
 import (
 	"encoding/binary"
+	"slices"
 	"sort"
 
 	"github.com/bits-and-blooms/bitset"
@@ -24,6 +25,11 @@ func (a *Array) Add(x uint16) bool {
 	return false
 }
 
+func (a *Array) Contains(x uint16) bool {
+	_, found := slices.BinarySearch(a.Values, x)
+	return found
+}
+
 func (a *Array) GetCardinality() uint16 {
 	return a.Cardinality
 }
@@ -57,7 +63,7 @@ func (a *Array) ConvertToRun() *Run {
 		}
 
 		last := r.Values[len(r.Values)-1]
-		if last.Start+last.Length == value {
+		if last.Start+last.Length+1 == value {
 			r.Values[len(r.Values)-1].Length++
 		} else {
 			r.Values = append(r.Values, RunRecord{Start: value, Length: 0})
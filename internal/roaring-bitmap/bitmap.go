@@ -22,6 +22,10 @@ func (b *Bitmap) Add(x uint16) bool {
 	return false
 }
 
+func (b *Bitmap) Contains(x uint16) bool {
+	return b.Values.Test(uint(x))
+}
+
 func (b *Bitmap) GetCardinality() uint16 {
 	return b.Cardinality
 }
@@ -75,13 +79,13 @@ func (b *Bitmap) CountNumberOfRuns() uint16 {
 	bcopy.FlipRange(0, bitmapSize)
 	bcopy.InPlaceIntersection(b.Values)
 
-	return uint16(b.Values.Count())
+	return uint16(bcopy.Count())
 }
 
 func (b *Bitmap) SerializeValues() []byte {
 	uint64Array := b.Values.Bytes()
 
-	byteArray := make([]byte, bitmapSize*8)
+	byteArray := make([]byte, BitmapWordsSize*8)
 	for i, v := range uint64Array {
 		binary.LittleEndian.PutUint64(byteArray[i*8:], v)
 	}
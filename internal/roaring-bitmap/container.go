@@ -1,7 +1,14 @@
 package roaring_bitmap
 
+import (
+	"slices"
+
+	"github.com/bits-and-blooms/bitset"
+)
+
 type Container interface {
 	Add(uint16) bool
+	Contains(uint16) bool
 	ConvertToArray() *Array
 	ConvertToBitmap() *Bitmap
 	ConvertToRun() *Run
@@ -29,3 +36,40 @@ func convertToBestType(c Container) Container {
 		}
 	}
 }
+
+// Clone returns a deep copy of c, so it can be modified or read independently of c.
+func Clone(c Container) Container {
+	switch c := c.(type) {
+	case *Array:
+		return &Array{Cardinality: c.Cardinality, Values: slices.Clone(c.Values)}
+	case *Bitmap:
+		return &Bitmap{Cardinality: c.Cardinality, Values: c.Values.Clone()}
+	case *Run:
+		return &Run{Cardinality: c.Cardinality, Values: slices.Clone(c.Values)}
+	}
+	return nil
+}
+
+// FromSortedValues builds a container from strictly increasing values at once,
+// which is much cheaper than adding them one by one.
+func FromSortedValues(values []uint16) Container {
+	if len(values) == 0 {
+		return nil
+	}
+
+	if len(values) <= MaxArraySize {
+		return convertToBestType(&Array{
+			Cardinality: uint16(len(values) - 1),
+			Values:      values,
+		})
+	}
+
+	b := &Bitmap{
+		Cardinality: uint16(len(values) - 1),
+		Values:      bitset.New(bitmapSize),
+	}
+	for _, v := range values {
+		b.Values.Set(uint(v))
+	}
+	return convertToBestType(b)
+}
@@ -161,7 +161,7 @@ func And(c1 Container, c2 Container) Container {
 				values := bitset.New(bitmapSize)
 
 				for _, v := range r {
-					values.FlipRange(uint(v.Start), uint(v.Start+v.Length))
+					values.FlipRange(uint(v.Start), uint(v.Start+v.Length)+1)
 				}
 				values.InPlaceIntersection(b)
 
@@ -324,8 +324,7 @@ func Or(c1 Container, c2 Container) Container {
 			b := c2.(*Bitmap).Values
 
 			cardinality := c2.GetCardinality()
-			values := bitset.New(bitmapSize)
-			values.Copy(b)
+			values := b.Clone()
 
 			for _, v := range a {
 				if !values.Test(uint(v)) {
@@ -373,10 +372,8 @@ func Or(c1 Container, c2 Container) Container {
 					j++
 				}
 			}
-			if lastRecord.Length > 0 {
-				cardinality += int(lastRecord.Length) + 1
-				values = append(values, lastRecord)
-			}
+			cardinality += int(lastRecord.Length) + 1
+			values = append(values, lastRecord)
 
 			result = &Run{
 				Cardinality: uint16(cardinality - 1),
@@ -414,7 +411,7 @@ func Or(c1 Container, c2 Container) Container {
 
 		lastRecord := RunRecord{}
 		var i, j int
-		for i < len(r) && j < len(rOther) {
+		for i < len(r) || j < len(rOther) {
 			if i < len(r) && (j == len(rOther) || r[i].Start <= rOther[j].Start) {
 				if i == 0 && j == 0 {
 					lastRecord = r[i]
@@ -439,10 +436,8 @@ func Or(c1 Container, c2 Container) Container {
 				j++
 			}
 		}
-		if lastRecord.Length > 0 {
-			cardinality += int(lastRecord.Length) + 1
-			values = append(values, lastRecord)
-		}
+		cardinality += int(lastRecord.Length) + 1
+		values = append(values, lastRecord)
 
 		result = &Run{
 			Cardinality: uint16(cardinality - 1),
@@ -454,74 +449,87 @@ func Or(c1 Container, c2 Container) Container {
 }
 
 func Not(c Container, docsCount uint16) Container {
-	var result Container
+	if docsCount == 0 {
+		return nil
+	}
+
+	var values *bitset.BitSet
 
 	switch c.(type) {
 	case nil:
-		values := bitset.New(bitmapSize)
-		values.SetAll()
-		values.FlipRange(uint(docsCount), bitmapSize)
-
-		result = &Bitmap{
-			Cardinality: docsCount - 1,
-			Values:      values,
-		}
+		values = bitset.New(bitmapSize)
+		values.FlipRange(0, uint(docsCount))
 	case *Array:
-		values := bitset.New(bitmapSize)
-		values.SetAll()
-		values.FlipRange(uint(docsCount), bitmapSize)
+		values = bitset.New(bitmapSize)
+		values.FlipRange(0, uint(docsCount))
 
 		for _, v := range c.(*Array).Values {
 			values.Clear(uint(v))
 		}
-
-		result = &Bitmap{
-			Cardinality: docsCount - 2 - c.GetCardinality(),
-			Values:      values,
-		}
 	case *Bitmap:
 		temp := bitset.New(bitmapSize)
-		temp.SetAll()
-		temp.FlipRange(uint(docsCount), bitmapSize)
+		temp.FlipRange(0, uint(docsCount))
 
-		values := c.(*Bitmap).Values.Complement()
+		values = c.(*Bitmap).Values.Complement()
 		values.InPlaceIntersection(temp)
-		result = &Bitmap{
-			Cardinality: docsCount - 2 - c.GetCardinality(),
-			Values:      values,
-		}
 	case *Run:
 		r := c.(*Run).Values
 
-		values := make([]RunRecord, 0, len(r)+1)
-
-		for i, v := range r {
-			if i == 0 {
-				if v.Start > 0 {
-					values = append(values, RunRecord{
-						Start:  0,
-						Length: v.Start - 1,
-					})
-				}
-			} else {
-				values = append(values, RunRecord{
-					Start:  r[i-1].Start + r[i-1].Length + 1,
-					Length: v.Start - (r[i-1].Start + r[i-1].Length) - 2,
+		runs := make([]RunRecord, 0, len(r)+1)
+		cardinality := 0
+		start := uint(0)
+		for _, v := range r {
+			if start >= uint(docsCount) {
+				break
+			}
+			if uint(v.Start) > start {
+				end := min(uint(v.Start), uint(docsCount))
+				runs = append(runs, RunRecord{
+					Start:  uint16(start),
+					Length: uint16(end - start - 1),
 				})
+				cardinality += int(end - start)
 			}
+			start = uint(v.Start) + uint(v.Length) + 1
 		}
-		if r[len(r)-1].Start+r[len(r)-1].Length < bitmapSize-1 {
-			values = append(values, RunRecord{
-				Start:  r[len(r)-1].Start + r[len(r)-1].Length + 1,
-				Length: bitmapSize - 1 - (r[len(r)-1].Start + 1 + r[len(r)-1].Length + 1),
+		if start < uint(docsCount) {
+			runs = append(runs, RunRecord{
+				Start:  uint16(start),
+				Length: uint16(uint(docsCount) - start - 1),
 			})
+			cardinality += int(uint(docsCount) - start)
 		}
 
-		result = &Run{
-			Cardinality: docsCount - 2 - c.GetCardinality(),
-			Values:      values,
+		if cardinality == 0 {
+			return nil
 		}
+		return convertToBestType(&Run{
+			Cardinality: uint16(cardinality - 1),
+			Values:      runs,
+		})
 	}
 
-	return convertToBestType(result)
+	if !values.Any() {
+		return nil
+	}
+	return convertToBestType(&Bitmap{
+		Cardinality: uint16(values.Count() - 1),
+		Values:      values,
+	})
+}
+
+// AndNot returns values of c1 that are not present in c2.
+func AndNot(c1 Container, c2 Container) Container {
+	if c1 == nil || c2 == nil {
+		return c1
+	}
+
+	values := c1.ConvertToBitmap().Values.Difference(c2.ConvertToBitmap().Values)
+	if !values.Any() {
+		return nil
+	}
+	return convertToBestType(&Bitmap{
+		Cardinality: uint16(values.Count() - 1),
+		Values:      values,
+	})
 }
@@ -0,0 +1,107 @@
+package roaring_bitmap
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func randValues(r *rand.Rand) map[uint16]struct{} {
+	values := make(map[uint16]struct{})
+	switch r.Intn(3) {
+	case 0:
+		for i := r.Intn(50); i > 0; i-- {
+			values[uint16(r.Intn(200))] = struct{}{}
+		}
+	case 1:
+		for i := r.Intn(6000); i > 0; i-- {
+			values[uint16(r.Intn(10000))] = struct{}{}
+		}
+	default:
+		start := r.Intn(1000)
+		for i := r.Intn(5); i >= 0; i-- {
+			length := r.Intn(3000)
+			for v := start; v < start+length; v++ {
+				values[uint16(v)] = struct{}{}
+			}
+			start += length + r.Intn(50) + 1
+		}
+	}
+	return values
+}
+
+func toContainer(values map[uint16]struct{}, containerType int) Container {
+	if len(values) == 0 {
+		return nil
+	}
+
+	sorted := make([]uint16, 0, len(values))
+	for v := range values {
+		sorted = append(sorted, v)
+	}
+	slices.Sort(sorted)
+
+	a := &Array{Cardinality: uint16(len(sorted) - 1), Values: sorted}
+	switch containerType {
+	case 0:
+		return a
+	case 1:
+		return a.ConvertToBitmap()
+	default:
+		return a.ConvertToRun()
+	}
+}
+
+func fromContainer(t *testing.T, c Container) []uint16 {
+	if c == nil {
+		return []uint16{}
+	}
+
+	values := c.ConvertToArray().Values
+	require.Equal(t, len(values), int(c.GetCardinality())+1)
+	return values
+}
+
+func sortedValues(values map[uint16]struct{}, filter func(uint16) bool) []uint16 {
+	result := make([]uint16, 0)
+	for v := range values {
+		if filter(v) {
+			result = append(result, v)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+func TestLogicalOperations(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	const docsCount = 12000
+
+	for i := 0; i < 300; i++ {
+		values1, values2 := randValues(r), randValues(r)
+		c1, c2 := toContainer(values1, r.Intn(3)), toContainer(values2, r.Intn(3))
+		contains := func(values map[uint16]struct{}, v uint16) bool {
+			_, ok := values[v]
+			return ok
+		}
+
+		union := make(map[uint16]struct{})
+		for v := range values1 {
+			union[v] = struct{}{}
+		}
+		for v := range values2 {
+			union[v] = struct{}{}
+		}
+		all := make(map[uint16]struct{})
+		for v := uint16(0); v < docsCount; v++ {
+			all[v] = struct{}{}
+		}
+
+		require.Equal(t, sortedValues(union, func(uint16) bool { return true }), fromContainer(t, Or(c1, c2)))
+		require.Equal(t, sortedValues(values1, func(v uint16) bool { return contains(values2, v) }), fromContainer(t, And(c1, c2)))
+		require.Equal(t, sortedValues(values1, func(v uint16) bool { return !contains(values2, v) }), fromContainer(t, AndNot(c1, c2)))
+		require.Equal(t, sortedValues(all, func(v uint16) bool { return !contains(values1, v) }), fromContainer(t, Not(c1, docsCount)))
+	}
+}
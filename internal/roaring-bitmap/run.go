@@ -52,6 +52,11 @@ func (r *Run) Add(x uint16) bool {
 	return false
 }
 
+func (r *Run) Contains(x uint16) bool {
+	idx := sort.Search(len(r.Values), func(i int) bool { return x < r.Values[i].Start })
+	return idx > 0 && r.Values[idx-1].Start+r.Values[idx-1].Length >= x
+}
+
 func (r *Run) GetCardinality() uint16 {
 	return r.Cardinality
 }
@@ -0,0 +1,152 @@
+package test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	inverted_index "inverted-index/internal/inverted-index"
+	"inverted-index/internal/lsm-tree/lsm_tree"
+)
+
+const (
+	generatedDocumentsNumber = 50000
+	generatedVocabularySize  = 20000
+	generatedDocumentLength  = 20
+)
+
+func generateDocuments(n int) []string {
+	r := rand.New(rand.NewSource(0))
+	documents := make([]string, n)
+	for j := range documents {
+		words := make([]string, generatedDocumentLength)
+		for k := range words {
+			words[k] = fmt.Sprintf("w%d", r.Intn(generatedVocabularySize))
+		}
+		documents[j] = strings.Join(words, " ")
+	}
+	return documents
+}
+
+// toBatch gives the jth document external ID docJ and created time J seconds after the epoch,
+// where J is j shifted by offset.
+func toBatch(documents []string, offset int) []inverted_index.BatchDocument {
+	batch := make([]inverted_index.BatchDocument, len(documents))
+	for j, document := range documents {
+		batch[j] = inverted_index.BatchDocument{
+			Reader: strings.NewReader(document),
+			Meta: inverted_index.DocumentMeta{
+				ExternalID:  fmt.Sprintf("doc%d", j+offset),
+				CreatedTime: time.Unix(int64(j+offset), 0),
+			},
+		}
+	}
+	return batch
+}
+
+func TestIndexBatch(t *testing.T) {
+	documents := generateDocuments(1000)
+
+	batchIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, batchIndex.Close()) })
+	docIDs, err := batchIndex.IndexBatch(toBatch(documents[:500], 0))
+	require.NoError(t, err)
+	require.Len(t, docIDs, 500)
+	require.Equal(t, uint16(499), docIDs[499])
+	docIDs, err = batchIndex.IndexBatch(toBatch(documents[500:], 500))
+	require.NoError(t, err)
+	require.Equal(t, uint16(500), docIDs[0])
+
+	sequentialIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, sequentialIndex.Close()) })
+	for j, document := range documents {
+		_, err = sequentialIndex.AddDocumentText(document, inverted_index.DocumentMeta{CreatedTime: time.Unix(int64(j), 0)})
+		require.NoError(t, err)
+	}
+
+	for _, term := range []string{"w0", "w1", "w42", "w19999"} {
+		expected, err := sequentialIndex.PreciseQuery(term)
+		require.NoError(t, err)
+		actual, err := batchIndex.PreciseQuery(term)
+		require.NoError(t, err)
+		require.Equal(t, sequentialIndex.ConvertFromContainer(expected), batchIndex.ConvertFromContainer(actual))
+	}
+
+	expected, err := sequentialIndex.DateQueryCreated(time.Unix(100, 0), time.Unix(700, 0))
+	require.NoError(t, err)
+	actual, err := batchIndex.DateQueryCreated(time.Unix(100, 0), time.Unix(700, 0))
+	require.NoError(t, err)
+	require.NotEmpty(t, batchIndex.ConvertFromContainer(actual))
+	require.Equal(t, sequentialIndex.ConvertFromContainer(expected), batchIndex.ConvertFromContainer(actual))
+
+	require.Equal(t, sequentialIndex.Complete("w1", 5), batchIndex.Complete("w1", 5))
+
+	document, err := batchIndex.LookupByExternalID("doc777")
+	require.NoError(t, err)
+	require.Equal(t, uint16(777), document.ID)
+}
+
+func TestIndexBatch_Failed(t *testing.T) {
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
+	_, err = invertedIndex.IndexBatch(toBatch([]string{"kept"}, 0))
+	require.NoError(t, err)
+
+	// the document store cannot encode a time past year 9999, so the batch fails after its postings are added
+	batch := toBatch([]string{"lost", "lost too"}, 1)
+	batch[1].Meta.CreatedTime = time.Date(10000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	_, err = invertedIndex.IndexBatch(batch)
+	require.Error(t, err)
+	_, err = invertedIndex.AddDocumentText("lost", batch[1].Meta)
+	require.Error(t, err)
+
+	container, err := invertedIndex.PreciseQuery("lost")
+	require.NoError(t, err)
+	require.Empty(t, invertedIndex.ConvertFromContainer(container))
+	_, err = invertedIndex.LookupByExternalID("doc1")
+	require.ErrorIs(t, err, inverted_index.ErrDocumentNotFound)
+
+	// the IDs of the failed documents are not reused
+	docIDs, err := invertedIndex.IndexBatch(toBatch([]string{"lost again"}, 3))
+	require.NoError(t, err)
+	require.Equal(t, []uint16{4}, docIDs)
+	container, err = invertedIndex.PreciseQuery("lost")
+	require.NoError(t, err)
+	require.Equal(t, []int{4}, invertedIndex.ConvertFromContainer(container))
+	require.Equal(t, []int{4, 0}, invertedIndex.ConvertFromContainer(invertedIndex.Not(nil)))
+}
+
+func BenchmarkAddDocument(b *testing.B) {
+	documents := generateDocuments(generatedDocumentsNumber)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: b.TempDir()})
+		require.NoError(b, err)
+		for j, document := range documents {
+			_, err = invertedIndex.AddDocumentText(document, inverted_index.DocumentMeta{CreatedTime: time.Unix(int64(j), 0)})
+			require.NoError(b, err)
+		}
+		require.NoError(b, invertedIndex.Close())
+	}
+}
+
+func BenchmarkIndexBatch(b *testing.B) {
+	documents := generateDocuments(generatedDocumentsNumber)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: b.TempDir()})
+		require.NoError(b, err)
+		_, err = invertedIndex.IndexBatch(toBatch(documents, 0))
+		require.NoError(b, err)
+		require.NoError(b, invertedIndex.Close())
+	}
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	inverted_index "inverted-index/internal/inverted-index"
+	"inverted-index/internal/lsm-tree/lsm_tree"
+)
+
+func TestIndexParallel(t *testing.T) {
+	documents := generateDocuments(1000)
+
+	parallelIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, parallelIndex.Close()) })
+	docIDs, err := parallelIndex.IndexParallel(toBatch(documents, 0), 4)
+	require.NoError(t, err)
+	require.Len(t, docIDs, len(documents))
+	for j, docID := range docIDs {
+		require.Equal(t, uint16(j), docID)
+	}
+
+	sequentialIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, sequentialIndex.Close()) })
+	for j, document := range documents {
+		_, err = sequentialIndex.AddDocumentText(document, inverted_index.DocumentMeta{CreatedTime: time.Unix(int64(j), 0)})
+		require.NoError(t, err)
+	}
+
+	for _, term := range []string{"w0", "w1", "w42", "w19999"} {
+		expected, err := sequentialIndex.PreciseQuery(term)
+		require.NoError(t, err)
+		actual, err := parallelIndex.PreciseQuery(term)
+		require.NoError(t, err)
+		require.Equal(t, sequentialIndex.ConvertFromContainer(expected), parallelIndex.ConvertFromContainer(actual))
+	}
+	require.Equal(t, sequentialIndex.Complete("w1", 5), parallelIndex.Complete("w1", 5))
+
+	document, err := parallelIndex.LookupByExternalID("doc321")
+	require.NoError(t, err)
+	require.Equal(t, uint16(321), document.ID)
+}
+
+// TestConcurrentQueries is meant to be run with -race: readers query the index
+// while it is being written to and check that results stay consistent.
+func TestConcurrentQueries(t *testing.T) {
+	documents := generateDocuments(2000)
+
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
+
+	var writing atomic.Bool
+	writing.Store(true)
+	errs := make(chan error, 5)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer writing.Store(false)
+
+		for offset := 0; offset < len(documents); offset += 250 {
+			_, err := invertedIndex.IndexParallel(toBatch(documents[offset:offset+250], offset), 0)
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+		for docID := uint16(0); docID < 100; docID++ {
+			if err := invertedIndex.UpdateDocumentText(docID, "updated", inverted_index.DocumentMeta{CreatedTime: time.Unix(1, 0)}); err != nil {
+				errs <- err
+				return
+			}
+		}
+		for docID := uint16(100); docID < 200; docID++ {
+			if err := invertedIndex.DeleteDocument(docID); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for writing.Load() {
+				container, err := invertedIndex.PreciseQuery("w7")
+				if err != nil {
+					errs <- err
+					return
+				}
+				docIDs := invertedIndex.ConvertFromContainer(container)
+				for j := 1; j < len(docIDs); j++ {
+					if docIDs[j-1] <= docIDs[j] {
+						t.Errorf("document IDs are not distinct and sorted: %v", docIDs)
+						return
+					}
+				}
+
+				_, err = invertedIndex.WildcardQuery("w123*")
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, err = invertedIndex.DateQueryCreated(time.Unix(0, 0), time.Unix(1000, 0))
+				if err != nil {
+					errs <- err
+					return
+				}
+				invertedIndex.Not(container)
+				invertedIndex.Complete("w2", 3)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	require.NoError(t, <-errs)
+
+	container, err := invertedIndex.PreciseQuery("updated")
+	require.NoError(t, err)
+	require.Len(t, invertedIndex.ConvertFromContainer(container), 100)
+
+	for _, term := range []string{"w0", "w7", "w42"} {
+		container, err := invertedIndex.PreciseQuery(term)
+		require.NoError(t, err)
+		for _, docID := range invertedIndex.ConvertFromContainer(container) {
+			require.GreaterOrEqual(t, docID, 200)
+		}
+	}
+}
@@ -1,17 +1,24 @@
 package test
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
 	inverted_index "inverted-index/internal/inverted-index"
+	"inverted-index/internal/lsm-tree/common"
+	"inverted-index/internal/lsm-tree/lsm_tree"
 )
 
 func TestSimple(t *testing.T) {
-	invertedIndex, err := inverted_index.New()
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
 	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
 
 	err = invertedIndex.AddDocument("./shakespeare.txt", time.Now(), nil)
 	require.NoError(t, err)
@@ -25,8 +32,9 @@ func TestSimple(t *testing.T) {
 }
 
 func TestOr(t *testing.T) {
-	invertedIndex, err := inverted_index.New()
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
 	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
 
 	err = invertedIndex.AddDocument("./shakespeare.txt", time.Now(), nil)
 	require.NoError(t, err)
@@ -46,8 +54,9 @@ func TestOr(t *testing.T) {
 }
 
 func TestAnd(t *testing.T) {
-	invertedIndex, err := inverted_index.New()
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
 	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
 
 	err = invertedIndex.AddDocument("./shakespeare.txt", time.Now(), nil)
 	require.NoError(t, err)
@@ -66,8 +75,9 @@ func TestAnd(t *testing.T) {
 }
 
 func TestNot(t *testing.T) {
-	invertedIndex, err := inverted_index.New()
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
 	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
 
 	err = invertedIndex.AddDocument("./shakespeare.txt", time.Now(), nil)
 	require.NoError(t, err)
@@ -87,8 +97,9 @@ func TestNot(t *testing.T) {
 }
 
 func TestWildcard(t *testing.T) {
-	invertedIndex, err := inverted_index.New()
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
 	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
 
 	err = invertedIndex.AddDocument("./shakespeare.txt", time.Now(), nil)
 	require.NoError(t, err)
@@ -130,8 +141,9 @@ func TestWildcard(t *testing.T) {
 }
 
 func TestDateQuery(t *testing.T) {
-	invertedIndex, err := inverted_index.New()
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
 	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
 
 	err = invertedIndex.AddDocument(
 		"./shakespeare.txt",
@@ -185,3 +197,289 @@ func TestDateQuery(t *testing.T) {
 	require.Len(t, docIDs, 1)
 	require.Equal(t, 0, docIDs[0])
 }
+
+func TestComplete(t *testing.T) {
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
+
+	err = invertedIndex.AddDocument("./shakespeare.txt", time.Now(), nil)
+	require.NoError(t, err)
+	err = invertedIndex.AddDocument("./some_words.txt", time.Now(), nil)
+	require.NoError(t, err)
+	err = invertedIndex.AddDocument("./disturbia.txt", time.Now(), nil)
+	require.NoError(t, err)
+
+	completions := invertedIndex.Complete("dia", 10)
+	require.Equal(t, []string{"diamond"}, completions)
+
+	completions = invertedIndex.Complete("di", 3)
+	require.Equal(t, []string{"diamond", "did", "die,"}, completions)
+
+	require.Empty(t, invertedIndex.Complete("di", 0))
+}
+
+func TestDeleteDocument(t *testing.T) {
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
+
+	err = invertedIndex.AddDocument("./shakespeare.txt", time.Now(), nil)
+	require.NoError(t, err)
+	err = invertedIndex.AddDocument("./some_words.txt", time.Now(), nil)
+	require.NoError(t, err)
+	err = invertedIndex.AddDocument("./disturbia.txt", time.Now(), nil)
+	require.NoError(t, err)
+
+	require.NoError(t, invertedIndex.DeleteDocument(1))
+	require.ErrorIs(t, invertedIndex.DeleteDocument(1), inverted_index.ErrDocumentNotFound)
+	require.ErrorIs(t, invertedIndex.DeleteDocument(3), inverted_index.ErrDocumentNotFound)
+
+	docIDsContainer, err := invertedIndex.PreciseQuery("diamond")
+	require.NoError(t, err)
+	docIDs := invertedIndex.ConvertFromContainer(docIDsContainer)
+	require.Equal(t, []int{0}, docIDs)
+
+	docIDsContainer, err = invertedIndex.PreciseQuery("comatose")
+	require.NoError(t, err)
+	require.Nil(t, docIDsContainer)
+
+	docIDsContainer, err = invertedIndex.PreciseQuery("disturbia")
+	require.NoError(t, err)
+	docIDs = invertedIndex.ConvertFromContainer(invertedIndex.Not(docIDsContainer))
+	require.Equal(t, []int{0}, docIDs)
+
+	docIDsContainer, err = invertedIndex.DateQueryCreated(time.Unix(0, 0), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	docIDs = invertedIndex.ConvertFromContainer(docIDsContainer)
+	require.Equal(t, []int{2, 0}, docIDs)
+}
+
+func TestUpdateDocument(t *testing.T) {
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
+
+	err = invertedIndex.AddDocument("./shakespeare.txt", time.Now(), nil)
+	require.NoError(t, err)
+	err = invertedIndex.AddDocument("./some_words.txt", time.Date(2014, time.April, 8, 4, 20, 0, 0, time.UTC), nil)
+	require.NoError(t, err)
+
+	updatedPath := filepath.Join(t.TempDir(), "updated.txt")
+	require.NoError(t, os.WriteFile(updatedPath, []byte("space oddity on the rose"), 0660))
+	err = invertedIndex.UpdateDocument(1, updatedPath, time.Date(1999, time.July, 11, 0, 0, 0, 0, time.UTC), nil)
+	require.NoError(t, err)
+	require.ErrorIs(t, invertedIndex.UpdateDocument(2, updatedPath, time.Now(), nil), inverted_index.ErrDocumentNotFound)
+
+	docIDsContainer, err := invertedIndex.PreciseQuery("comatose")
+	require.NoError(t, err)
+	require.Nil(t, docIDsContainer)
+
+	docIDsContainer, err = invertedIndex.PreciseQuery("oddity")
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, invertedIndex.ConvertFromContainer(docIDsContainer))
+
+	docIDsContainer, err = invertedIndex.PreciseQuery("diamond")
+	require.NoError(t, err)
+	require.Equal(t, []int{0}, invertedIndex.ConvertFromContainer(docIDsContainer))
+
+	docIDsContainer, err = invertedIndex.PreciseQuery("rose")
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 0}, invertedIndex.ConvertFromContainer(docIDsContainer))
+
+	docIDsContainer, err = invertedIndex.DateQueryCreated(
+		time.Date(1999, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, invertedIndex.ConvertFromContainer(docIDsContainer))
+
+	require.Equal(t, []string{"diamond"}, invertedIndex.Complete("dia", 10))
+}
+
+func TestGetDocument(t *testing.T) {
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
+
+	createdTime := time.Date(2014, time.April, 8, 4, 20, 0, 0, time.UTC)
+	err = invertedIndex.AddDocument("./shakespeare.txt", time.Now(), nil)
+	require.NoError(t, err)
+	err = invertedIndex.AddDocumentWithFields("./some_words.txt", map[string]string{"title": "some words"}, createdTime, nil)
+	require.NoError(t, err)
+
+	document, err := invertedIndex.GetDocument(1)
+	require.NoError(t, err)
+	require.Equal(t, "./some_words.txt", document.ExternalID)
+	require.Equal(t, "some words", document.Fields["title"])
+	require.True(t, createdTime.Equal(document.CreatedTime))
+
+	document, err = invertedIndex.LookupByExternalID("./shakespeare.txt")
+	require.NoError(t, err)
+	require.Equal(t, uint16(0), document.ID)
+
+	require.NoError(t, invertedIndex.DeleteDocument(0))
+	_, err = invertedIndex.GetDocument(0)
+	require.ErrorIs(t, err, inverted_index.ErrDocumentNotFound)
+	_, err = invertedIndex.LookupByExternalID("./shakespeare.txt")
+	require.ErrorIs(t, err, inverted_index.ErrDocumentNotFound)
+}
+
+func TestDocumentStoreOutsideStorage(t *testing.T) {
+	dir := t.TempDir()
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: dir})
+	require.NoError(t, err)
+	_, err = invertedIndex.AddDocumentText("apple", inverted_index.DocumentMeta{ExternalID: "apple"})
+	require.NoError(t, err)
+	require.NoError(t, invertedIndex.Close())
+
+	// opening the storage removes the files of its data directory it does not know
+	storage, err := lsm_tree.Open(lsm_tree.Options{Dir: dir})
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+	info, err := os.Stat(filepath.Join(dir, "documents"))
+	require.NoError(t, err)
+	require.Positive(t, info.Size())
+}
+
+func TestAddDocumentText(t *testing.T) {
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
+
+	file, err := os.Open("./shakespeare.txt")
+	require.NoError(t, err)
+	defer file.Close()
+	docID, err := invertedIndex.AddDocumentReader(file, inverted_index.DocumentMeta{
+		ExternalID:  "shakespeare",
+		CreatedTime: time.Now(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint16(0), docID)
+
+	// a single line far longer than the default bufio.Scanner limit
+	longTerm := strings.Repeat("x", 100*1024)
+	text := strings.Repeat("filler ", 20*1024) + "diamond " + longTerm
+	docID, err = invertedIndex.AddDocumentText(text, inverted_index.DocumentMeta{
+		ExternalID:  "generated",
+		CreatedTime: time.Now(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), docID)
+
+	docIDsContainer, err := invertedIndex.PreciseQuery("diamond")
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 0}, invertedIndex.ConvertFromContainer(docIDsContainer))
+
+	docIDsContainer, err = invertedIndex.PreciseQuery(longTerm)
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, invertedIndex.ConvertFromContainer(docIDsContainer))
+
+	document, err := invertedIndex.LookupByExternalID("generated")
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), document.ID)
+}
+
+func TestPreciseQueryAcrossFlushes(t *testing.T) {
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
+
+	_, err = invertedIndex.AddDocumentText("needle", inverted_index.DocumentMeta{})
+	require.NoError(t, err)
+	// more distinct terms than common.FirstLevelSize, so the RAM component is flushed
+	// with the first posting of needle in it
+	words := make([]string, 3*common.FirstLevelSize)
+	for j := range words {
+		words[j] = fmt.Sprintf("u%d", j)
+	}
+	_, err = invertedIndex.AddDocumentText(strings.Join(words, " "), inverted_index.DocumentMeta{})
+	require.NoError(t, err)
+	_, err = invertedIndex.AddDocumentText("needle", inverted_index.DocumentMeta{})
+	require.NoError(t, err)
+
+	docIDsContainer, err := invertedIndex.PreciseQuery("needle")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int{0, 2}, invertedIndex.ConvertFromContainer(docIDsContainer))
+}
+
+func TestPreciseQueryAcrossLevels(t *testing.T) {
+	invertedIndex, err := inverted_index.NewWithOptions(lsm_tree.Options{
+		Dir:          t.TempDir(),
+		MemTableKeys: 500,
+		LevelFanOut:  2,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, invertedIndex.Close()) })
+
+	documents := generateDocuments(2000)
+	expected := make(map[string][]int)
+	for j, document := range documents {
+		_, err = invertedIndex.AddDocumentText(document, inverted_index.DocumentMeta{})
+		require.NoError(t, err)
+		for _, term := range strings.Fields(document) {
+			if len(expected[term]) == 0 || expected[term][0] != j {
+				expected[term] = append([]int{j}, expected[term]...)
+			}
+		}
+	}
+
+	for _, term := range []string{"w0", "w1", "w42", "w19999"} {
+		docIDsContainer, err := invertedIndex.PreciseQuery(term)
+		require.NoError(t, err)
+		require.ElementsMatch(t, expected[term], invertedIndex.ConvertFromContainer(docIDsContainer), term)
+	}
+}
+
+func TestExpiredDocuments(t *testing.T) {
+	dir := t.TempDir()
+	options := lsm_tree.Options{CompactionFilter: lsm_tree.NewTTLFilter(time.Hour)}
+	invertedIndex, err := inverted_index.OpenWithOptions(dir, options)
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-2 * time.Hour)
+	inGrace := time.Now().Add(-time.Minute)
+	_, err = invertedIndex.AddDocumentText("apple cherry", inverted_index.DocumentMeta{DieTime: &expired})
+	require.NoError(t, err)
+	_, err = invertedIndex.AddDocumentText("apple", inverted_index.DocumentMeta{DieTime: &inGrace})
+	require.NoError(t, err)
+	_, err = invertedIndex.AddDocumentText("pear", inverted_index.DocumentMeta{})
+	require.NoError(t, err)
+
+	// expired documents are left out by every query, whether the storage dropped them or not
+	checkExpired := func() {
+		docIDsContainer, err := invertedIndex.PreciseQuery("pear")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []int{1}, invertedIndex.ConvertFromContainer(invertedIndex.Not(docIDsContainer)))
+		docIDsContainer, err = invertedIndex.PreciseQuery("apple")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []int{1}, invertedIndex.ConvertFromContainer(docIDsContainer))
+		docIDsContainer, err = invertedIndex.WildcardQuery("app*")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []int{1}, invertedIndex.ConvertFromContainer(docIDsContainer))
+		// terms found only in expired documents are not completed
+		require.Empty(t, invertedIndex.Complete("ch", 10))
+		require.Equal(t, []string{"apple", "pear"}, invertedIndex.Complete("", 10))
+	}
+	checkExpired()
+	require.NoError(t, invertedIndex.Close())
+
+	// die times are restored from the stored documents
+	options.CompactionFilter = lsm_tree.NewTTLFilter(time.Hour)
+	invertedIndex, err = inverted_index.OpenWithOptions(dir, options)
+	require.NoError(t, err)
+	checkExpired()
+
+	// a document updated to live forever is not expired anymore
+	require.NoError(t, invertedIndex.UpdateDocumentText(0, "apple", inverted_index.DocumentMeta{}))
+	docIDsContainer, err := invertedIndex.PreciseQuery("pear")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int{0, 1}, invertedIndex.ConvertFromContainer(invertedIndex.Not(docIDsContainer)))
+	docIDsContainer, err = invertedIndex.PreciseQuery("apple")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int{0, 1}, invertedIndex.ConvertFromContainer(docIDsContainer))
+	require.Equal(t, []string{"apple", "pear"}, invertedIndex.Complete("", 10))
+	require.NoError(t, invertedIndex.UpdateDocumentText(0, "apple cherry", inverted_index.DocumentMeta{}))
+	require.Equal(t, []string{"apple", "cherry", "pear"}, invertedIndex.Complete("", 10))
+	require.NoError(t, invertedIndex.Close())
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	inverted_index "inverted-index/internal/inverted-index"
+)
+
+func TestOpenClose(t *testing.T) {
+	dir := t.TempDir()
+	documents := generateDocuments(1000)
+
+	invertedIndex, err := inverted_index.Open(dir)
+	require.NoError(t, err)
+	_, err = invertedIndex.IndexBatch(toBatch(documents, 0))
+	require.NoError(t, err)
+
+	// enough distinct terms to flush the RAM component to an sstable
+	words := make([]string, 150000)
+	for j := range words {
+		words[j] = fmt.Sprintf("u%d", j)
+	}
+	bigDocID, err := invertedIndex.AddDocumentText(strings.Join(words, " "), inverted_index.DocumentMeta{
+		ExternalID:  "big",
+		CreatedTime: time.Unix(5000, 0),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, invertedIndex.DeleteDocument(3))
+	require.NoError(t, invertedIndex.UpdateDocumentText(4, "w0 replaced", inverted_index.DocumentMeta{CreatedTime: time.Unix(4, 0)}))
+
+	terms := []string{"w0", "w1", "w42", "w19999", "u0", "u12345", "replaced"}
+	expected := make(map[string][]int)
+	for _, term := range terms {
+		container, err := invertedIndex.PreciseQuery(term)
+		require.NoError(t, err)
+		expected[term] = invertedIndex.ConvertFromContainer(container)
+	}
+	expectedCreated, err := invertedIndex.DateQueryCreated(time.Unix(100, 0), time.Unix(700, 0))
+	require.NoError(t, err)
+	expectedNot := invertedIndex.Not(nil)
+	expectedCompletion := invertedIndex.Complete("w1", 5)
+	require.NoError(t, invertedIndex.Close())
+	tables, err := os.ReadDir(filepath.Join(dir, "lsm", "data"))
+	require.NoError(t, err)
+	require.NotEmpty(t, tables)
+
+	invertedIndex, err = inverted_index.Open(dir)
+	require.NoError(t, err)
+
+	for _, term := range terms {
+		container, err := invertedIndex.PreciseQuery(term)
+		require.NoError(t, err)
+		require.Equal(t, expected[term], invertedIndex.ConvertFromContainer(container), term)
+	}
+	actualCreated, err := invertedIndex.DateQueryCreated(time.Unix(100, 0), time.Unix(700, 0))
+	require.NoError(t, err)
+	require.Equal(t, invertedIndex.ConvertFromContainer(expectedCreated), invertedIndex.ConvertFromContainer(actualCreated))
+	require.Equal(t, invertedIndex.ConvertFromContainer(expectedNot), invertedIndex.ConvertFromContainer(invertedIndex.Not(nil)))
+	require.Equal(t, expectedCompletion, invertedIndex.Complete("w1", 5))
+
+	document, err := invertedIndex.LookupByExternalID("big")
+	require.NoError(t, err)
+	require.Equal(t, bigDocID, document.ID)
+	_, err = invertedIndex.GetDocument(3)
+	require.ErrorIs(t, err, inverted_index.ErrDocumentNotFound)
+
+	// the reopened index keeps working: new IDs continue and old documents can be changed
+	docID, err := invertedIndex.AddDocumentText("w0 appended", inverted_index.DocumentMeta{})
+	require.NoError(t, err)
+	require.Equal(t, bigDocID+1, docID)
+	require.NoError(t, invertedIndex.UpdateDocumentText(4, "other", inverted_index.DocumentMeta{CreatedTime: time.Unix(4, 0)}))
+
+	container, err := invertedIndex.PreciseQuery("replaced")
+	require.NoError(t, err)
+	require.Empty(t, invertedIndex.ConvertFromContainer(container))
+	container, err = invertedIndex.PreciseQuery("appended")
+	require.NoError(t, err)
+	require.Equal(t, []int{int(docID)}, invertedIndex.ConvertFromContainer(container))
+	require.NoError(t, invertedIndex.Close())
+}
+
+func TestOpen_NotClosed(t *testing.T) {
+	dir := t.TempDir()
+
+	invertedIndex, err := inverted_index.Open(dir)
+	require.NoError(t, err)
+	_, err = invertedIndex.IndexBatch(toBatch(generateDocuments(10), 0))
+	require.NoError(t, err)
+	appleDocID, err := invertedIndex.AddDocumentText("apple pie", inverted_index.DocumentMeta{})
+	require.NoError(t, err)
+	lastDocID, err := invertedIndex.AddDocumentText("apple juice", inverted_index.DocumentMeta{})
+	require.NoError(t, err)
+	require.NoError(t, invertedIndex.UpdateDocumentText(4, "w0 replaced", inverted_index.DocumentMeta{}))
+	require.NoError(t, invertedIndex.DeleteDocument(lastDocID))
+	// the index is abandoned without Close, as on a crash
+
+	for reopening := range 2 {
+		invertedIndex, err = inverted_index.Open(dir)
+		require.NoError(t, err)
+
+		// the IDs of both the live and the deleted documents are not given again
+		docID, err := invertedIndex.AddDocumentText("banana", inverted_index.DocumentMeta{})
+		require.NoError(t, err)
+		require.Equal(t, lastDocID+1+uint16(reopening), docID)
+		container, err := invertedIndex.PreciseQuery("apple")
+		require.NoError(t, err)
+		require.Equal(t, []int{int(appleDocID)}, invertedIndex.ConvertFromContainer(container))
+		container, err = invertedIndex.PreciseQuery("replaced")
+		require.NoError(t, err)
+		require.Equal(t, []int{4}, invertedIndex.ConvertFromContainer(container))
+		require.NoError(t, invertedIndex.DeleteDocument(docID))
+
+		if reopening == 0 {
+			// the recovered documents can be changed
+			require.NoError(t, invertedIndex.UpdateDocumentText(appleDocID, "apple tart", inverted_index.DocumentMeta{}))
+			require.NoError(t, invertedIndex.DeleteDocument(5))
+			require.ErrorIs(t, invertedIndex.DeleteDocument(lastDocID), inverted_index.ErrDocumentNotFound)
+		} else {
+			container, err = invertedIndex.PreciseQuery("tart")
+			require.NoError(t, err)
+			require.Equal(t, []int{int(appleDocID)}, invertedIndex.ConvertFromContainer(container))
+			_, err = invertedIndex.GetDocument(5)
+			require.ErrorIs(t, err, inverted_index.ErrDocumentNotFound)
+			require.NoError(t, invertedIndex.Close())
+		}
+	}
+}